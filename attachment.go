@@ -0,0 +1,329 @@
+package vkapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	attachmentRefReg *regexp.Regexp
+)
+
+func init() {
+	attachmentRefReg = regexp.MustCompile(`^(photo|video|audio|doc|wall|market|market_album|poll|note|page|album|topic|story|audio_playlist|podcast|article|graffiti|product)(-?[0-9]+)_([0-9]+)(?:_([a-zA-Z0-9]+))?$`)
+}
+
+// AttachmentRef - ссылка на объект ВК вида photo-1_2_accesskey
+type AttachmentRef struct {
+	Type      string
+	OwnerID   int
+	ID        int
+	AccessKey string
+}
+
+// String - строка для параметра attachment
+func (r AttachmentRef) String() string {
+	str := r.Type + strconv.Itoa(r.OwnerID) + "_" + strconv.Itoa(r.ID)
+	if r.AccessKey != "" {
+		str += "_" + r.AccessKey
+	}
+	return str
+}
+
+// JoinAttachments - склеиваем ссылки для параметра attachment
+func JoinAttachments(refs ...AttachmentRef) string {
+	arr := make([]string, len(refs))
+	for i, r := range refs {
+		arr[i] = r.String()
+	}
+	return strings.Join(arr, ",")
+}
+
+// ParseAttachment - разбираем строку аттача (photo-1_2_key) или ссылку (https://vk.com/wall-1_2, vk.com/club1?w=wall-1_2)
+func ParseAttachment(str string) (ref AttachmentRef, err error) {
+	str = strings.TrimSpace(str)
+
+	if m := attachmentRefReg.FindStringSubmatch(str); m != nil {
+		return attachmentRefFromMatch(m), nil
+	}
+
+	if !strings.Contains(str, "vk.com/") {
+		err = errors.New("not an attachment: " + str)
+		return
+	}
+	if !strings.Contains(str, "://") {
+		str = "https://" + str
+	}
+
+	u, err := url.Parse(str)
+	if err != nil {
+		return
+	}
+
+	// Объект открыт поверх страницы: vk.com/club1?w=wall-1_2 или ?z=photo-1_2/album-1_0
+	candidates := []string{u.Query().Get("w"), u.Query().Get("z"), strings.Trim(u.Path, "/")}
+	for _, c := range candidates {
+		if i := strings.Index(c, "/"); i != -1 {
+			c = c[:i]
+		}
+		if m := attachmentRefReg.FindStringSubmatch(c); m != nil {
+			return attachmentRefFromMatch(m), nil
+		}
+	}
+
+	err = errors.New("not an attachment link: " + str)
+	return
+}
+
+func attachmentRefFromMatch(m []string) (ref AttachmentRef) {
+	ref.Type = m[1]
+	ref.OwnerID, _ = strconv.Atoi(m[2])
+	ref.ID, _ = strconv.Atoi(m[3])
+	ref.AccessKey = m[4]
+
+	// Товар в ссылках - product, в аттачах - market
+	if ref.Type == "product" {
+		ref.Type = "market"
+	}
+	return
+}
+
+// Ref - ссылка на объект аттача. ok = false для аттачей без своей страницы (ссылки, стикеры, карточки)
+func (a *Attachments) Ref() (ref AttachmentRef, ok bool) {
+	var raw *json.RawMessage
+	switch a.Type {
+	case "photo":
+		raw = a.Photo
+	case "video":
+		raw = a.Video
+	case "audio":
+		raw = a.Audio
+	case "doc":
+		raw = a.Doc
+	case "poll":
+		raw = a.Poll
+	case "page":
+		raw = a.Page
+	case "album":
+		raw = a.Album
+	case "note":
+		raw = a.Note
+	case "market":
+		raw = a.Market
+	}
+	if raw == nil {
+		return
+	}
+
+	var obj struct {
+		ID        json.RawMessage `json:"id"`
+		OwnerID   int             `json:"owner_id"`
+		GroupID   int             `json:"group_id"`
+		AccessKey string          `json:"access_key"`
+	}
+	if json.Unmarshal(*raw, &obj) != nil {
+		return
+	}
+
+	// У альбомов id бывает строкой
+	id, err := strconv.Atoi(strings.Trim(string(obj.ID), `"`))
+	if err != nil {
+		return
+	}
+
+	ref = AttachmentRef{Type: a.Type, OwnerID: obj.OwnerID, ID: id, AccessKey: obj.AccessKey}
+	if a.Type == "page" && ref.OwnerID == 0 {
+		ref.OwnerID = -obj.GroupID
+	}
+
+	ok = true
+	return
+}
+
+/*
+	Типизированные аттачи
+*/
+
+// TypedAttachment - разобранный аттач, заполнено одно поле по Type
+// Если тип неизвестен или объект не удалось разобрать, исходный JSON объекта лежит в Unknown
+type TypedAttachment struct {
+	Type         string
+	Photo        *PhotosGetItem
+	Video        *VideoGetItem
+	Audio        *AttachmentsAudio
+	AudioMessage *AttachmentsAudioMessage
+	Doc          *AttachmentsDoc
+	Link         *AttachmentsLink
+	Poll         *PollItem
+	Market       *MarketGetByIDAns
+	MarketAlbum  *AttachmentsMarketAlbum
+	Sticker      *AttachmentsSticker
+	Page         *AttachmentsPage
+	Album        *PhotosGetAlbumsItem
+	Note         *AttachmentsNote
+	PrettyCards  *AttachmentsPrettyCards
+	Graffiti     *AttachmentsGraffiti
+	Article      *AttachmentsArticle
+	Event        *AttachmentsEvent
+	Wall         *WallGetByIDAns
+	WallReply    *WallGetCommentsItem
+	Unknown      json.RawMessage
+}
+
+// UnmarshalJSON - разбираем аттач вида {"type":"photo","photo":{...}}
+// Если type нет или он не строка - весь объект попадает в Unknown, ошибки нет
+func (t *TypedAttachment) UnmarshalJSON(data []byte) (err error) {
+	var m map[string]json.RawMessage
+	var kind string
+	if json.Unmarshal(data, &m) != nil || json.Unmarshal(m["type"], &kind) != nil {
+		*t = TypedAttachment{Unknown: append(json.RawMessage(nil), data...)}
+		return
+	}
+
+	*t = newTypedAttachment(kind, m[kind])
+	return
+}
+
+// DecodeTypedAttachments - сразу разбираем массив аттачей в типизированные объекты
+func DecodeTypedAttachments(data []byte) (atts []TypedAttachment, err error) {
+	err = json.Unmarshal(data, &atts)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	return
+}
+
+// UnmarshalJSON - разбираем аттач и сразу заполняем Typed
+// Typed берется из исходного JSON, чтобы не потерять типы без своего поля в Attachments (wall, graffiti, article...)
+func (a *Attachments) UnmarshalJSON(data []byte) (err error) {
+	type attachments Attachments
+	var obj attachments
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return
+	}
+	*a = Attachments(obj)
+
+	a.Typed = &TypedAttachment{}
+	a.Typed.UnmarshalJSON(data)
+	return
+}
+
+// GetTyped - типизированный аттач, разобранный при UnmarshalJSON
+// Для Attachments, собранного вручную, объект разбирается из полей при каждом вызове
+func (a *Attachments) GetTyped() (t TypedAttachment) {
+	if a.Typed != nil {
+		return *a.Typed
+	}
+
+	var raw *json.RawMessage
+	switch a.Type {
+	case "photo":
+		raw = a.Photo
+	case "video":
+		raw = a.Video
+	case "audio":
+		raw = a.Audio
+	case "doc":
+		raw = a.Doc
+	case "link":
+		raw = a.Link
+	case "poll":
+		raw = a.Poll
+	case "page":
+		raw = a.Page
+	case "album":
+		raw = a.Album
+	case "note":
+		raw = a.Note
+	case "sticker":
+		raw = a.Sticker
+	case "pretty_cards":
+		raw = a.PrettyCards
+	case "market":
+		raw = a.Market
+	}
+	if raw == nil {
+		return TypedAttachment{Type: a.Type}
+	}
+
+	return newTypedAttachment(a.Type, *raw)
+}
+
+func newTypedAttachment(kind string, raw json.RawMessage) (t TypedAttachment) {
+	t.Type = kind
+
+	var v interface{}
+	switch kind {
+	case "photo":
+		t.Photo = &PhotosGetItem{}
+		v = t.Photo
+	case "video":
+		t.Video = &VideoGetItem{}
+		v = t.Video
+	case "audio":
+		t.Audio = &AttachmentsAudio{}
+		v = t.Audio
+	case "audio_message":
+		t.AudioMessage = &AttachmentsAudioMessage{}
+		v = t.AudioMessage
+	case "doc":
+		t.Doc = &AttachmentsDoc{}
+		v = t.Doc
+	case "link":
+		t.Link = &AttachmentsLink{}
+		v = t.Link
+	case "poll":
+		t.Poll = &PollItem{}
+		v = t.Poll
+	case "market":
+		t.Market = &MarketGetByIDAns{}
+		v = t.Market
+	case "market_album":
+		t.MarketAlbum = &AttachmentsMarketAlbum{}
+		v = t.MarketAlbum
+	case "sticker":
+		t.Sticker = &AttachmentsSticker{}
+		v = t.Sticker
+	case "page":
+		t.Page = &AttachmentsPage{}
+		v = t.Page
+	case "album":
+		t.Album = &PhotosGetAlbumsItem{}
+		v = t.Album
+	case "note":
+		t.Note = &AttachmentsNote{}
+		v = t.Note
+	case "pretty_cards":
+		t.PrettyCards = &AttachmentsPrettyCards{}
+		v = t.PrettyCards
+	case "graffiti":
+		t.Graffiti = &AttachmentsGraffiti{}
+		v = t.Graffiti
+	case "article":
+		t.Article = &AttachmentsArticle{}
+		v = t.Article
+	case "event":
+		t.Event = &AttachmentsEvent{}
+		v = t.Event
+	case "wall":
+		t.Wall = &WallGetByIDAns{}
+		v = t.Wall
+	case "wall_reply":
+		t.WallReply = &WallGetCommentsItem{}
+		v = t.WallReply
+	}
+
+	if v == nil || len(raw) == 0 || json.Unmarshal(raw, v) != nil {
+		return TypedAttachment{Type: kind, Unknown: raw}
+	}
+
+	return
+}
@@ -0,0 +1,53 @@
+package vkapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAttachmentsTyped(t *testing.T) {
+	data := `[{"type":"photo","photo":{"id":1,"owner_id":2}},{"type":"graffiti","graffiti":{"id":3,"url":"u"}},{"type":"story","story":{"id":9}},{"type":"album","album":{"id":"saved"}},{"photo":{}}]`
+
+	var atts []Attachments
+	if err := json.Unmarshal([]byte(data), &atts); err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range atts {
+		if a.Typed == nil {
+			t.Fatal(i, "not decoded")
+		}
+	}
+
+	if atts[0].Typed.Photo == nil || atts[0].Typed.Photo.ID != 1 || atts[0].GetPhoto().OwnerID != 2 {
+		t.Fatal(atts[0].Typed)
+	}
+	if atts[0].GetLink().URL != "" || atts[1].GetPhoto().ID != 0 {
+		t.Fatal("getter of another type is not empty")
+	}
+	if atts[1].GetTyped().Graffiti.URL != "u" {
+		t.Fatal(atts[1].Typed)
+	}
+	if string(atts[2].Typed.Unknown) != `{"id":9}` || atts[3].Typed.Unknown == nil {
+		t.Fatal("no Unknown fallback")
+	}
+	if string(atts[4].Typed.Unknown) != `{"photo":{}}` {
+		t.Fatal(string(atts[4].Typed.Unknown))
+	}
+
+	typed, err := DecodeTypedAttachments([]byte(`[{"type":"doc","doc":{"id":4}},{"type":5}]`))
+	if err != nil || typed[0].Doc.ID != 4 || string(typed[1].Unknown) != `{"type":5}` {
+		t.Fatal(err, typed)
+	}
+
+	// Собранный вручную аттач разбирается из полей
+	raw := json.RawMessage(`{"id":5}`)
+	a := Attachments{Type: "photo", Photo: &raw}
+	if a.GetTyped().Photo.ID != 5 || a.GetPhoto().ID != 5 {
+		t.Fatal(a.GetTyped())
+	}
+
+	var empty Attachments
+	if empty.GetTyped().Type != "" || empty.GetPoll().ID != 0 {
+		t.Fatal("zero value")
+	}
+}
@@ -0,0 +1,321 @@
+package vkapi
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OAuthStateTTL - сколько живет state по умолчанию
+	OAuthStateTTL = 15 * time.Minute
+	// OAuthStateCookie - префикс cookie с nonce, которым state привязан к браузеру
+	// У каждого state своя cookie, поэтому авторизации в соседних вкладках не мешают друг другу
+	OAuthStateCookie = "vk_oauth_state"
+)
+
+// OAuthStateData - данные сохраненного state
+type OAuthStateData struct {
+	Payload      string
+	CodeVerifier string
+	// Nonce - значение cookie браузера, начавшего авторизацию
+	Nonce string
+}
+
+// OAuthStateStore - хранилище выданных state
+// Consume должен удалять state, чтобы его нельзя было использовать повторно
+type OAuthStateStore interface {
+	Save(state string, data OAuthStateData, ttl time.Duration) (err error)
+	Consume(state string) (data OAuthStateData, ok bool, err error)
+}
+
+// MemoryOAuthStateStore - хранилище state в памяти
+type MemoryOAuthStateStore struct {
+	h map[string]memoryOAuthState
+	sync.Mutex
+}
+
+type memoryOAuthState struct {
+	data OAuthStateData
+	exp  time.Time
+}
+
+// NewMemoryOAuthStateStore - создаем хранилище state в памяти
+func NewMemoryOAuthStateStore() *MemoryOAuthStateStore {
+	return &MemoryOAuthStateStore{h: make(map[string]memoryOAuthState)}
+}
+
+// Save - сохраняем state
+func (s *MemoryOAuthStateStore) Save(state string, data OAuthStateData, ttl time.Duration) (err error) {
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	for k, v := range s.h {
+		if now.After(v.exp) {
+			delete(s.h, k)
+		}
+	}
+
+	s.h[state] = memoryOAuthState{data: data, exp: now.Add(ttl)}
+	return
+}
+
+// Consume - забираем state, ok = false если его нет или он протух
+func (s *MemoryOAuthStateStore) Consume(state string) (data OAuthStateData, ok bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	v, ok := s.h[state]
+	if !ok {
+		return
+	}
+	delete(s.h, state)
+
+	if time.Now().After(v.exp) {
+		ok = false
+		return
+	}
+
+	data = v.data
+	return
+}
+
+// OAuthResult - результат авторизации
+type OAuthResult struct {
+	// Payload - данные, переданные при формировании ссылки
+	Payload string
+	// Token - токен пользователя
+	Token GetTokenAns
+	// Group - токены сообществ, если Token.IsGroup
+	Group GetTokenGroupAns
+}
+
+// OAuthHandler - http обработчик redirect_uri
+// state проверяется через States (хранимый) или StateSecret (подписанный), без них запрос отклоняется
+// state привязан к браузеру через cookie с nonce, поэтому чужую ссылку возврата подсунуть нельзя
+type OAuthHandler struct {
+	API *API
+	// Token - данные приложения для обмена code, Code заполняется из запроса
+	Token    TokenData
+	States   OAuthStateStore
+	StateTTL time.Duration
+	// StateSecret - ключ подписи state, используется если States не задан
+	StateSecret []byte
+	// OnResult - вызывается после успешного получения токена, должен ответить пользователю
+	// Без него обработчик не обменивает code и отвечает ошибкой
+	OnResult func(w http.ResponseWriter, r *http.Request, res OAuthResult)
+	// OnError - ответ при ошибке, по умолчанию отдаем статус
+	// Отказ пользователя приходит как *OAuthError с IsAccessDenied
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+	// CookieName - префикс имени cookie с nonce, по умолчанию OAuthStateCookie
+	CookieName string
+	// InsecureCookie - не ставить cookie флаг Secure (для разработки по http)
+	InsecureCookie bool
+}
+
+// AuthURL - ссылка авторизации с новым state, в w ставится cookie с nonce
+// При хранимом state дополнительно включается PKCE
+func (h *OAuthHandler) AuthURL(w http.ResponseWriter, d AuthURLData, payload string) (link string, err error) {
+	if d.ClientID == 0 {
+		d.ClientID = h.Token.ClientID
+	}
+	if d.RedirectURI == "" {
+		d.RedirectURI = h.Token.RedirectURI
+	}
+
+	nonce, err := NewOAuthState()
+	if err != nil {
+		return
+	}
+
+	switch {
+	case h.States != nil:
+		var p PKCE
+		p, err = NewPKCE()
+		if err != nil {
+			return
+		}
+
+		d.State, err = NewOAuthState()
+		if err != nil {
+			return
+		}
+
+		err = h.States.Save(d.State, OAuthStateData{Payload: payload, CodeVerifier: p.Verifier, Nonce: nonce}, h.stateTTL())
+		if err != nil {
+			return
+		}
+		d.CodeChallenge = p.Challenge
+
+	case h.StateSecret != nil:
+		// nonce без символа |, поэтому отделяем им payload
+		d.State, err = SignOAuthState(h.StateSecret, nonce+"|"+payload)
+		if err != nil {
+			return
+		}
+
+	default:
+		err = errors.New("oauth handler: no state store or secret")
+		return
+	}
+
+	// Lax - cookie должна прийти при переходе обратно с oauth.vk.com
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.cookieName(d.State),
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(h.stateTTL() / time.Second),
+		HttpOnly: true,
+		Secure:   !h.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	link = GetAuthURL(d)
+	return
+}
+
+// ServeHTTP - обрабатываем возврат пользователя с oauth.vk.com
+func (h *OAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.OnResult == nil {
+		log.Println("[error]", "oauth handler: no OnResult")
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := h.Exchange(r)
+
+	// state одноразовый, cookie больше не нужна
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.cookieName(r.URL.Query().Get("state")),
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   !h.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	h.OnResult(w, r, res)
+}
+
+// Exchange - проверяем state и cookie браузера, затем обмениваем code на токен
+func (h *OAuthHandler) Exchange(r *http.Request) (res OAuthResult, err error) {
+	q := r.URL.Query()
+	d := h.Token
+
+	// Сначала state - он одноразовый и должен быть погашен даже при отказе пользователя
+	var nonce string
+	switch {
+	case h.States != nil:
+		var data OAuthStateData
+		var ok bool
+		data, ok, err = h.States.Consume(q.Get("state"))
+		if err != nil {
+			return
+		}
+		if !ok {
+			err = ErrOAuthStateInvalid
+			return
+		}
+		nonce = data.Nonce
+		res.Payload = data.Payload
+		d.CodeVerifier = data.CodeVerifier
+
+	case h.StateSecret != nil:
+		var payload string
+		payload, err = VerifyOAuthState(h.StateSecret, q.Get("state"), h.stateTTL())
+		if err != nil {
+			return
+		}
+		i := strings.Index(payload, "|")
+		if i == -1 {
+			err = ErrOAuthStateInvalid
+			return
+		}
+		nonce, res.Payload = payload[:i], payload[i+1:]
+
+	default:
+		err = ErrOAuthStateInvalid
+		return
+	}
+
+	c, cerr := r.Cookie(h.cookieName(q.Get("state")))
+	if cerr != nil || nonce == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(nonce)) != 1 {
+		err = ErrOAuthStateInvalid
+		return
+	}
+
+	if q.Get("error") != "" {
+		err = &OAuthError{Code: q.Get("error"), Description: q.Get("error_description")}
+		return
+	}
+
+	d.Code = q.Get("code")
+	if d.Code == "" {
+		err = errors.New("no code in request")
+		return
+	}
+
+	vk := h.API
+	if vk == nil {
+		vk = &API{}
+	}
+
+	if d.IsGroup {
+		res.Group, err = vk.GetTokenGroups(d)
+	} else {
+		res.Token, err = vk.GetToken(d)
+	}
+
+	return
+}
+
+func (h *OAuthHandler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if h.OnError != nil {
+		h.OnError(w, r, err)
+		return
+	}
+
+	if oerr, ok := err.(*OAuthError); ok && oerr.IsAccessDenied() {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
+	log.Println("[error]", err)
+	if err == ErrOAuthStateInvalid || err == ErrOAuthStateExpired {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	http.Error(w, "error", http.StatusBadGateway)
+}
+
+// Имя cookie для state: префикс и начало хеша state
+func (h *OAuthHandler) cookieName(state string) string {
+	name := h.CookieName
+	if name == "" {
+		name = OAuthStateCookie
+	}
+
+	sum := sha256.Sum256([]byte(state))
+	return name + "_" + hex.EncodeToString(sum[:8])
+}
+
+func (h *OAuthHandler) stateTTL() time.Duration {
+	if h.StateTTL > 0 {
+		return h.StateTTL
+	}
+	return OAuthStateTTL
+}
@@ -0,0 +1,144 @@
+package vkapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOAuthHandler(t *testing.T) {
+	oauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.FormValue("code") != "c" || !VerifyPKCE(r.FormValue("code_verifier"), lastChallenge) {
+			w.WriteHeader(401)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"tok","user_id":7}`))
+	}))
+	defer oauth.Close()
+
+	var got OAuthResult
+	h := &OAuthHandler{Token: TokenData{ClientID: 1, RedirectURI: "https://app/cb", TokenURL: oauth.URL}, States: NewMemoryOAuthStateStore(),
+		OnResult: func(w http.ResponseWriter, r *http.Request, res OAuthResult) { got = res }}
+	start := func(hh *OAuthHandler, payload string) (string, *http.Cookie) {
+		w := httptest.NewRecorder()
+		link, err := hh.AuthURL(w, AuthURLData{}, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := w.Result().Cookies()
+		if len(c) != 1 || !c[0].HttpOnly || !c[0].Secure || c[0].SameSite != http.SameSiteLaxMode {
+			t.Fatal(c)
+		}
+		u, _ := url.Parse(link)
+		lastChallenge = u.Query().Get("code_challenge")
+		return u.Query().Get("state"), c[0]
+	}
+	req := func(q string, c *http.Cookie) *http.Request {
+		r := httptest.NewRequest("GET", "/cb?"+q, nil)
+		if c != nil {
+			r.AddCookie(c)
+		}
+		return r
+	}
+
+	// Две авторизации в соседних вкладках
+	st1, c1 := start(h, "/one")
+	st2, c2 := start(h, "/two")
+	if c1.Name == c2.Name {
+		t.Fatal("same cookie for different states")
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req("code=c&state="+url.QueryEscape(st2), c2))
+	if got.Payload != "/two" {
+		t.Fatal(got)
+	}
+	lastChallenge = ""
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req("error=access_denied&state="+url.QueryEscape(st1), c1))
+	if rec.Code != 403 {
+		t.Fatal("first tab", rec.Code)
+	}
+
+	st, c := start(h, "/home")
+	// чужой браузер без cookie
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("code=c&state="+url.QueryEscape(st), nil))
+	if rec.Code != 400 {
+		t.Fatal("nocookie", rec.Code)
+	}
+
+	st, c = start(h, "/home")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("code=c&state="+url.QueryEscape(st), &http.Cookie{Name: c.Name, Value: "x"}))
+	if rec.Code != 400 {
+		t.Fatal("badcookie", rec.Code)
+	}
+
+	st, c = start(h, "/home")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("code=c&state="+url.QueryEscape(st), c))
+	if got.Token.UserID != 7 || got.Payload != "/home" {
+		t.Fatal(rec.Code, rec.Body.String(), got)
+	}
+	if cc := rec.Result().Cookies(); len(cc) != 1 || cc[0].MaxAge >= 0 {
+		t.Fatal("clear", cc)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("code=c&state="+url.QueryEscape(st), c))
+	if rec.Code != 400 {
+		t.Fatal("replay", rec.Code)
+	}
+
+	// отказ без state - 400, с state - 403 и state погашен
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("error=access_denied", c))
+	if rec.Code != 400 {
+		t.Fatal(rec.Code)
+	}
+	st, c = start(h, "/home")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req("error=access_denied&state="+url.QueryEscape(st), c))
+	if rec.Code != 403 {
+		t.Fatal(rec.Code)
+	}
+	if _, ok, _ := h.States.Consume(st); ok {
+		t.Fatal("not consumed")
+	}
+
+	h2 := &OAuthHandler{Token: TokenData{TokenURL: oauth.URL}, StateSecret: []byte("s")}
+	st, c = start(h2, "p|q")
+	if _, err := h2.Exchange(req("code=bad&state="+url.QueryEscape(st), c)); err == nil || err == ErrOAuthStateInvalid {
+		t.Fatal(err)
+	}
+	if _, err := h2.Exchange(req("code=bad&state="+url.QueryEscape(st), nil)); err != ErrOAuthStateInvalid {
+		t.Fatal(err)
+	}
+	lastChallenge = ""
+	res, err := h2.Exchange(req("error=access_denied&state="+url.QueryEscape(st), c))
+	if oe, ok := err.(*OAuthError); !ok || !oe.IsAccessDenied() || res.Payload != "p|q" {
+		t.Fatal(err, res)
+	}
+}
+
+func TestOAuthHandlerNoResult(t *testing.T) {
+	h := &OAuthHandler{States: NewMemoryOAuthStateStore()}
+	w := httptest.NewRecorder()
+	link, err := h.AuthURL(w, AuthURLData{ClientID: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(link)
+	st := u.Query().Get("state")
+
+	r := httptest.NewRequest("GET", "/cb?code=c&state="+url.QueryEscape(st), nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatal(rec.Code)
+	}
+}
+
+var lastChallenge string
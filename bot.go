@@ -0,0 +1,496 @@
+package vkapi
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BotHandlerFunc - обработчик сообщения бота
+type BotHandlerFunc func(ctx *BotContext) error
+
+// BotState - состояние диалога с пользователем
+type BotState struct {
+	Scene string            `json:"scene"`
+	Data  map[string]string `json:"data"`
+}
+
+// Копия состояния, чтобы обработчики разных событий не делили один Data
+func (st BotState) clone() BotState {
+	if st.Data == nil {
+		return st
+	}
+
+	data := make(map[string]string, len(st.Data))
+	for k, v := range st.Data {
+		data[k] = v
+	}
+	st.Data = data
+	return st
+}
+
+// BotStateStore - хранилище состояний диалогов
+type BotStateStore interface {
+	Get(peerID int) (state BotState, err error)
+	Set(peerID int, state BotState) (err error)
+	Delete(peerID int) (err error)
+}
+
+// MemoryBotStateStore - хранилище состояний в памяти
+type MemoryBotStateStore struct {
+	h map[int]BotState
+	sync.RWMutex
+}
+
+// NewMemoryBotStateStore - создаем хранилище состояний в памяти
+func NewMemoryBotStateStore() *MemoryBotStateStore {
+	return &MemoryBotStateStore{h: make(map[int]BotState)}
+}
+
+// Get - состояние диалога, Data копируется и его можно менять
+func (s *MemoryBotStateStore) Get(peerID int) (state BotState, err error) {
+	s.RLock()
+	state = s.h[peerID].clone()
+	s.RUnlock()
+	return
+}
+
+// Set - сохраняем копию состояния диалога
+func (s *MemoryBotStateStore) Set(peerID int, state BotState) (err error) {
+	state = state.clone()
+	s.Lock()
+	if s.h == nil {
+		s.h = make(map[int]BotState)
+	}
+	s.h[peerID] = state
+	s.Unlock()
+	return
+}
+
+// Delete - удаляем состояние диалога
+func (s *MemoryBotStateStore) Delete(peerID int) (err error) {
+	s.Lock()
+	delete(s.h, peerID)
+	s.Unlock()
+	return
+}
+
+/*
+	Маршруты
+*/
+
+type botRoute struct {
+	match func(ctx *BotContext) bool
+	f     BotHandlerFunc
+}
+
+// BotRoutes - список обработчиков, проверяются по порядку добавления
+type BotRoutes struct {
+	routes []botRoute
+	// Default - если ни один обработчик не подошел
+	Default BotHandlerFunc
+}
+
+// Handle - обработчик с произвольным условием
+func (r *BotRoutes) Handle(match func(ctx *BotContext) bool, f BotHandlerFunc) {
+	r.routes = append(r.routes, botRoute{match: match, f: f})
+}
+
+// Command - команда текстом вида "/name аргументы" или payload {"cmd":"name"}
+func (r *BotRoutes) Command(name string, f BotHandlerFunc) {
+	r.Handle(func(ctx *BotContext) bool {
+		if ctx.Command() == name {
+			return true
+		}
+
+		if ctx.Message == nil {
+			return false
+		}
+
+		fields := strings.Fields(ctx.Message.Text)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "/"+name) {
+			return false
+		}
+
+		ctx.Args = fields[1:]
+		return true
+	}, f)
+}
+
+// Payload - команда только из payload {"cmd":"name"}, в том числе нажатие callback кнопки
+func (r *BotRoutes) Payload(cmd string, f BotHandlerFunc) {
+	r.Handle(func(ctx *BotContext) bool {
+		return ctx.Command() == cmd
+	}, f)
+}
+
+// Regexp - текст сообщения подходит под регулярку, группы попадут в ctx.Matches
+func (r *BotRoutes) Regexp(re *regexp.Regexp, f BotHandlerFunc) {
+	r.Handle(func(ctx *BotContext) bool {
+		if ctx.Message == nil {
+			return false
+		}
+
+		ctx.Matches = re.FindStringSubmatch(ctx.Message.Text)
+		return ctx.Matches != nil
+	}, f)
+}
+
+// Text - текст сообщения совпадает без учета регистра
+func (r *BotRoutes) Text(text string, f BotHandlerFunc) {
+	r.Handle(func(ctx *BotContext) bool {
+		return ctx.Message != nil && strings.EqualFold(strings.TrimSpace(ctx.Message.Text), text)
+	}, f)
+}
+
+// Ищем подходящий обработчик
+func (r *BotRoutes) find(ctx *BotContext) BotHandlerFunc {
+	for _, rt := range r.routes {
+		ctx.Args = nil
+		ctx.Matches = nil
+		if rt.match(ctx) {
+			return rt.f
+		}
+	}
+	return nil
+}
+
+// BotScene - сцена диалога со своими обработчиками
+type BotScene struct {
+	BotRoutes
+	Name    string
+	OnEnter BotHandlerFunc
+	OnLeave BotHandlerFunc
+}
+
+/*
+	Бот
+*/
+
+// Bot - бот поверх событий callback
+// Метод Handle можно использовать как OnEvent обработчика, очереди или роутера
+// Без NewBot состояния тоже хранятся в памяти, если States не задан
+type Bot struct {
+	BotRoutes
+	API    *API
+	States BotStateStore
+	// Send - отправка сообщения, если nil то API.MessagesSend
+	Send func(params map[string]string) (int, error)
+	// AnswerEvent - ответ на нажатие callback кнопки, если nil то API.AnswerMessageEvent
+	AnswerEvent func(e CallbackMessageEvent, data *MessageEventData) error
+
+	scenes     map[string]*BotScene
+	statesOnce sync.Once
+}
+
+// NewBot - создаем бота, состояния хранятся в памяти
+func NewBot(vk *API) *Bot {
+	return &Bot{
+		API:    vk,
+		States: NewMemoryBotStateStore(),
+		scenes: make(map[string]*BotScene),
+	}
+}
+
+// Scene - получаем или создаем сцену
+func (b *Bot) Scene(name string) *BotScene {
+	if s, ok := b.scenes[name]; ok {
+		return s
+	}
+
+	if b.scenes == nil {
+		b.scenes = make(map[string]*BotScene)
+	}
+
+	s := &BotScene{Name: name}
+	b.scenes[name] = s
+	return s
+}
+
+// Handle - обрабатываем событие message_new или message_event
+// Сначала обработчики текущей сцены, потом общие, потом Default сцены и бота
+func (b *Bot) Handle(cbo *CallBackObj) (err error) {
+	err = cbo.Parse()
+	if err != nil {
+		return
+	}
+
+	ctx := &BotContext{Bot: b, Event: cbo}
+	switch cbo.Type {
+	case "message_new":
+		ctx.Message = &cbo.Message
+		ctx.PeerID = cbo.Message.PeerID
+		ctx.FromID = cbo.Message.FromID
+	case "message_event":
+		ctx.MessageEvent = &cbo.MessageEvent
+		ctx.PeerID = cbo.MessageEvent.PeerID
+		ctx.FromID = cbo.MessageEvent.UserID
+	default:
+		return
+	}
+
+	ctx.State, err = b.states().Get(ctx.PeerID)
+	if err != nil {
+		return
+	}
+
+	scene := b.scenes[ctx.State.Scene]
+
+	var f BotHandlerFunc
+	if scene != nil {
+		f = scene.find(ctx)
+	}
+	if f == nil {
+		f = b.find(ctx)
+	}
+	if f == nil && scene != nil {
+		f = scene.Default
+	}
+	if f == nil {
+		f = b.Default
+	}
+	if f == nil {
+		return
+	}
+
+	return f(ctx)
+}
+
+// Хранилище состояний, по умолчанию в памяти
+func (b *Bot) states() BotStateStore {
+	b.statesOnce.Do(func() {
+		if b.States == nil {
+			b.States = NewMemoryBotStateStore()
+		}
+	})
+	return b.States
+}
+
+func (b *Bot) send(params map[string]string) (int, error) {
+	if b.Send != nil {
+		return b.Send(params)
+	}
+	return b.API.MessagesSend(params)
+}
+
+func (b *Bot) answerEvent(e CallbackMessageEvent, data *MessageEventData) (err error) {
+	if b.AnswerEvent != nil {
+		return b.AnswerEvent(e, data)
+	}
+	_, err = b.API.AnswerMessageEvent(e, data)
+	return
+}
+
+// BotContext - контекст обработки сообщения
+type BotContext struct {
+	Bot          *Bot
+	Event        *CallBackObj
+	Message      *MessagesGetAns       // для message_new
+	MessageEvent *CallbackMessageEvent // для message_event
+	PeerID       int
+	FromID       int
+	State        BotState
+	// Args - аргументы команды после /name
+	Args []string
+	// Matches - группы регулярки
+	Matches []string
+}
+
+// Command - команда из payload
+func (ctx *BotContext) Command() string {
+	if ctx.Message != nil {
+		return ctx.Message.PayloadCommand()
+	}
+	if ctx.MessageEvent != nil {
+		return ctx.MessageEvent.PayloadCommand()
+	}
+	return ""
+}
+
+// DecodePayload - разбираем payload сообщения или нажатия
+func (ctx *BotContext) DecodePayload(v interface{}) error {
+	if ctx.Message != nil {
+		return ctx.Message.DecodePayload(v)
+	}
+	if ctx.MessageEvent != nil {
+		return ctx.MessageEvent.DecodePayload(v)
+	}
+	return ErrNoPayload
+}
+
+// Reply - отвечаем в диалог, peer_id и random_id заполняются сами
+func (ctx *BotContext) Reply(text string, params map[string]string) (int, error) {
+	p := copyParams(params)
+	if text != "" {
+		p["message"] = text
+	}
+	p["peer_id"] = strconv.Itoa(ctx.PeerID)
+	if p["random_id"] == "" {
+		p["random_id"] = strconv.Itoa(int(NewRandomID()))
+	}
+
+	return ctx.Bot.send(p)
+}
+
+// ReplyKeyboard - отвечаем с клавиатурой
+func (ctx *BotContext) ReplyKeyboard(text string, k *Keyboard) (id int, err error) {
+	params := make(map[string]string)
+	err = k.ToParams(params)
+	if err != nil {
+		return
+	}
+
+	return ctx.Reply(text, params)
+}
+
+// Answer - отвечаем на нажатие callback кнопки, data может быть nil
+func (ctx *BotContext) Answer(data *MessageEventData) (err error) {
+	if ctx.MessageEvent == nil {
+		return
+	}
+	return ctx.Bot.answerEvent(*ctx.MessageEvent, data)
+}
+
+// Set - сохраняем значение в состоянии диалога
+func (ctx *BotContext) Set(key, value string) (err error) {
+	if ctx.State.Data == nil {
+		ctx.State.Data = make(map[string]string)
+	}
+	ctx.State.Data[key] = value
+	return ctx.Bot.states().Set(ctx.PeerID, ctx.State)
+}
+
+// Get - значение из состояния диалога
+func (ctx *BotContext) Get(key string) string {
+	return ctx.State.Data[key]
+}
+
+// Enter - переходим в сцену, данные состояния сбрасываются
+func (ctx *BotContext) Enter(name string) (err error) {
+	err = ctx.Leave()
+	if err != nil {
+		return
+	}
+
+	ctx.State = BotState{Scene: name}
+	err = ctx.Bot.states().Set(ctx.PeerID, ctx.State)
+	if err != nil {
+		return
+	}
+
+	if s := ctx.Bot.scenes[name]; s != nil && s.OnEnter != nil {
+		err = s.OnEnter(ctx)
+	}
+	return
+}
+
+// Leave - выходим из текущей сцены
+func (ctx *BotContext) Leave() (err error) {
+	if ctx.State.Scene == "" {
+		return
+	}
+
+	if s := ctx.Bot.scenes[ctx.State.Scene]; s != nil && s.OnLeave != nil {
+		err = s.OnLeave(ctx)
+		if err != nil {
+			return
+		}
+	}
+
+	ctx.State = BotState{}
+	return ctx.Bot.states().Delete(ctx.PeerID)
+}
+
+/*
+	Тестирование ботов
+*/
+
+// BotTester - прогон бота на поддельных событиях без обращения к ВК
+type BotTester struct {
+	Bot     *Bot
+	GroupID int
+	// Replies - отправленные ботом сообщения
+	Replies []map[string]string
+	// Answers - ответы на нажатия callback кнопок
+	Answers []*MessageEventData
+
+	lastID int
+	sync.Mutex
+}
+
+// NewBotTester - подменяем отправку сообщений у бота и собираем ответы
+func NewBotTester(b *Bot) *BotTester {
+	t := &BotTester{Bot: b, GroupID: 1}
+
+	b.Send = func(params map[string]string) (int, error) {
+		t.Lock()
+		defer t.Unlock()
+		t.lastID++
+		t.Replies = append(t.Replies, params)
+		return t.lastID, nil
+	}
+	b.AnswerEvent = func(e CallbackMessageEvent, data *MessageEventData) error {
+		t.Lock()
+		t.Answers = append(t.Answers, data)
+		t.Unlock()
+		return nil
+	}
+
+	return t
+}
+
+// SendMessage - пользователь пишет боту, payload может быть пустым
+func (t *BotTester) SendMessage(peerID int, text, payload string) (err error) {
+	t.Lock()
+	t.lastID++
+	m := MessagesGetAns{ID: t.lastID, PeerID: peerID, FromID: peerID, Text: text, Payload: payload}
+	t.Unlock()
+
+	return t.feed("message_new", m)
+}
+
+// Press - пользователь нажимает callback кнопку
+func (t *BotTester) Press(peerID int, payload string) (err error) {
+	e := CallbackMessageEvent{
+		UserID:  peerID,
+		PeerID:  peerID,
+		EventID: strconv.Itoa(int(NewRandomID())),
+	}
+	if payload != "" {
+		e.Payload = json.RawMessage(payload)
+	}
+
+	return t.feed("message_event", e)
+}
+
+// LastReply - последнее отправленное ботом сообщение
+func (t *BotTester) LastReply() (params map[string]string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.Replies) > 0 {
+		params = t.Replies[len(t.Replies)-1]
+	}
+	return
+}
+
+// Reset - очищаем собранные ответы
+func (t *BotTester) Reset() {
+	t.Lock()
+	t.Replies = nil
+	t.Answers = nil
+	t.Unlock()
+}
+
+// Отдаем событие боту так же как его прислал бы ВК
+func (t *BotTester) feed(eventType string, obj interface{}) (err error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+
+	cbo := &CallBackObj{Type: eventType, GroupID: t.GroupID, Object: b}
+	return t.Bot.Handle(cbo)
+}
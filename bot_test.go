@@ -0,0 +1,79 @@
+package vkapi
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Запускать с -race: события одного диалога приходят параллельно
+func TestBotHandleConcurrentState(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	b := NewBot(nil)
+	b.Default = func(ctx *BotContext) error {
+		if err := ctx.Set("last", ctx.Message.Text); err != nil {
+			return err
+		}
+		_, err := ctx.Reply(ctx.Get("last"), nil)
+		return err
+	}
+	bt := NewBotTester(b)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := bt.SendMessage(1, strconv.Itoa(i), ""); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(bt.Replies) != 200 {
+		t.Fatalf("%d replies, want 200", len(bt.Replies))
+	}
+
+	state, _ := b.States.Get(1)
+	if state.Data["last"] == "" {
+		t.Fatal("state not saved")
+	}
+}
+
+func TestBotScene(t *testing.T) {
+	b := NewBot(nil)
+	b.Command("start", func(ctx *BotContext) error {
+		return ctx.Enter("name")
+	})
+
+	s := b.Scene("name")
+	s.OnEnter = func(ctx *BotContext) error {
+		_, err := ctx.Reply("name?", nil)
+		return err
+	}
+	s.Default = func(ctx *BotContext) error {
+		if err := ctx.Set("name", ctx.Message.Text); err != nil {
+			return err
+		}
+		_, err := ctx.Reply("hi "+ctx.Get("name"), nil)
+		return err
+	}
+	bt := NewBotTester(b)
+
+	if err := bt.SendMessage(1, "/start", ""); err != nil {
+		t.Fatal(err)
+	}
+	if r := bt.LastReply(); r["message"] != "name?" || r["peer_id"] != "1" {
+		t.Fatal(r)
+	}
+
+	if err := bt.SendMessage(1, "Ivan", ""); err != nil {
+		t.Fatal(err)
+	}
+	if r := bt.LastReply(); r["message"] != "hi Ivan" {
+		t.Fatal(r)
+	}
+}
@@ -0,0 +1,190 @@
+package vkapi
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// BulkPeerIDsChunk - максимум получателей в одном messages.send
+	BulkPeerIDsChunk = 100
+	// BulkRatePerSecond - лимит запросов сообщества в секунду
+	BulkRatePerSecond = 20
+)
+
+// BulkSender - массовая рассылка сообщений через peer_ids
+type BulkSender struct {
+	API *API
+	// RatePerSecond - максимум запросов в секунду, по умолчанию BulkRatePerSecond
+	RatePerSecond int
+	// MaxAttempts - сколько раз пытаемся отправить пачку или получателю с временной ошибкой
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// SendBatch - отправка пачки, если nil то API.MessagesSendPeerIDs
+	SendBatch func(params map[string]string) ([]MessagesSendPeerAns, error)
+}
+
+// BulkReport - отчет о рассылке
+type BulkReport struct {
+	Total  int
+	Sent   int
+	Failed int
+	// MessageIDs - id сообщения по получателю
+	MessageIDs map[int]int
+	// FailedPeers - причина ошибки по получателю
+	FailedPeers map[int]string
+	// Errors - кол-во ошибок по причине
+	Errors map[string]int
+}
+
+// BulkNoAnswer - причина для получателя, о котором ВК ничего не ответил
+const BulkNoAnswer = "no answer for peer"
+
+// Временные ошибки ВК, после которых есть смысл повторить отправку
+var bulkTransientCodes = map[int]bool{
+	1:  true, // Unknown error
+	6:  true, // Too many requests per second
+	9:  true, // Flood control
+	10: true, // Internal server error
+}
+
+// Пачка получателей со своим random_id
+type bulkBatch struct {
+	peers    []int
+	randomID int32
+}
+
+// Send - отправляем сообщение всем получателям, params - параметры messages.send без peer_id(s)
+// У каждой пачки свой random_id: при повторе пачки после сетевой ошибки он тот же, чтобы не задвоить сообщения,
+// а получателям с ошибкой от ВК сообщение точно не ушло, и они уходят новой пачкой с новым random_id
+func (s *BulkSender) Send(peerIDs []int, params map[string]string) (report BulkReport) {
+	report = BulkReport{
+		Total:       len(peerIDs),
+		MessageIDs:  make(map[int]int),
+		FailedPeers: make(map[int]string),
+		Errors:      make(map[string]int),
+	}
+
+	maxAttempts := s.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	retryDelay := s.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = time.Second
+	}
+	rate := s.RatePerSecond
+	if rate <= 0 {
+		rate = BulkRatePerSecond
+	}
+	interval := time.Second / time.Duration(rate)
+
+	base := copyParams(params)
+	delete(base, "peer_id")
+	delete(base, "user_id")
+
+	// random_id пачек выводим из общего ключа рассылки
+	key := base["random_id"]
+	if key == "" {
+		key = strconv.Itoa(int(NewRandomID()))
+	}
+	delete(base, "random_id")
+
+	var seq int
+	newBatches := func(peers []int) (batches []bulkBatch) {
+		for _, chunk := range chunkSliceInt(peers, BulkPeerIDsChunk) {
+			batches = append(batches, bulkBatch{peers: chunk, randomID: RandomIDFromKey(key + ":" + strconv.Itoa(seq))})
+			seq++
+		}
+		return
+	}
+
+	var last time.Time
+	pending := newBatches(peerIDs)
+	for attempt := 1; attempt <= maxAttempts && len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			time.Sleep(retryDelay)
+		}
+
+		var retry []bulkBatch
+		var retryPeers []int
+		for _, b := range pending {
+			// Соблюдаем лимит запросов
+			if wait := interval - time.Since(last); wait > 0 {
+				time.Sleep(wait)
+			}
+			last = time.Now()
+
+			p := copyParams(base)
+			p["peer_ids"] = joinInts(b.peers)
+			p["random_id"] = strconv.Itoa(int(b.randomID))
+
+			ans, err := s.send(p)
+			if err != nil {
+				// Сетевая ошибка - неизвестно, ушла ли пачка, повторяем с тем же random_id
+				// Постоянная ошибка ВК (неверный параметр, сообщения запрещены) - повтор не поможет
+				reason := err.Error()
+				again := true
+				if aerr, ok := err.(*APIError); ok {
+					reason = strconv.Itoa(aerr.Code) + ": " + aerr.Error()
+					again = bulkTransientCodes[aerr.Code]
+				}
+
+				for _, id := range b.peers {
+					report.FailedPeers[id] = reason
+				}
+				if again {
+					retry = append(retry, b)
+				}
+				continue
+			}
+
+			// Получатели без ответа: неизвестно, дошло ли им сообщение, повторять нельзя
+			answered := make(map[int]bool, len(ans))
+			for _, a := range ans {
+				answered[a.PeerID] = true
+			}
+			for _, id := range b.peers {
+				if !answered[id] {
+					report.FailedPeers[id] = BulkNoAnswer
+				}
+			}
+
+			for _, a := range ans {
+				if a.Error == nil {
+					report.MessageIDs[a.PeerID] = a.MessageID
+					delete(report.FailedPeers, a.PeerID)
+					continue
+				}
+
+				report.FailedPeers[a.PeerID] = strconv.Itoa(a.Error.Code) + ": " + a.Error.Description
+				if bulkTransientCodes[a.Error.Code] {
+					retryPeers = append(retryPeers, a.PeerID)
+				}
+			}
+		}
+
+		pending = append(retry, newBatches(retryPeers)...)
+	}
+
+	report.Sent = len(report.MessageIDs)
+	report.Failed = len(report.FailedPeers)
+	for _, reason := range report.FailedPeers {
+		report.Errors[reason]++
+	}
+
+	return
+}
+
+func (s *BulkSender) send(params map[string]string) (ans []MessagesSendPeerAns, err error) {
+	if s.SendBatch != nil {
+		ans, err = s.SendBatch(params)
+	} else {
+		ans, err = s.API.MessagesSendPeerIDs(params)
+	}
+	if err != nil {
+		log.Println("[error]", err)
+	}
+	return
+}
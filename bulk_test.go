@@ -0,0 +1,93 @@
+package vkapi
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func bulkPeers(n int) (peers []int) {
+	for i := 1; i <= n; i++ {
+		peers = append(peers, i)
+	}
+	return
+}
+
+func TestBulkSenderRetry(t *testing.T) {
+	var calls int
+	randomIDs := make(map[string]bool)
+	byPeers := make(map[string]string)
+
+	s := &BulkSender{RetryDelay: time.Millisecond, RatePerSecond: 1000, SendBatch: func(p map[string]string) ([]MessagesSendPeerAns, error) {
+		calls++
+		randomIDs[p["random_id"]] = true
+
+		// Пачку после сетевой ошибки повторяем с тем же random_id
+		if old, ok := byPeers[p["peer_ids"]]; ok && old != p["random_id"] {
+			t.Fatal("batch retry changed random_id")
+		}
+		byPeers[p["peer_ids"]] = p["random_id"]
+
+		if calls == 2 {
+			return nil, errors.New("Internal Server Error")
+		}
+
+		var ans []MessagesSendPeerAns
+		for _, str := range strings.Split(p["peer_ids"], ",") {
+			id, _ := strconv.Atoi(str)
+			a := MessagesSendPeerAns{PeerID: id, MessageID: id * 10}
+			if id%50 == 0 {
+				a.Error = &MessagesSendPeerError{Code: 901, Description: "Can't send messages for users without permission"}
+			}
+			if id == 7 && calls < 4 {
+				a.Error = &MessagesSendPeerError{Code: 10, Description: "Internal server error"}
+			}
+			ans = append(ans, a)
+		}
+		return ans, nil
+	}}
+
+	r := s.Send(bulkPeers(250), map[string]string{"message": "hi"})
+	if r.Sent != 245 || r.Failed != 5 || r.MessageIDs[7] != 70 {
+		t.Fatal(r.Sent, r.Failed, r.Errors)
+	}
+	// 3 пачки и новая пачка для получателя с временной ошибкой
+	if len(randomIDs) != 4 {
+		t.Fatal(randomIDs)
+	}
+}
+
+func TestBulkSenderPermanentError(t *testing.T) {
+	var calls int
+	s := &BulkSender{RetryDelay: time.Millisecond, RatePerSecond: 1000, SendBatch: func(p map[string]string) ([]MessagesSendPeerAns, error) {
+		calls++
+		return nil, &APIError{Method: "messages.send", Code: 100, msg: "One of the parameters specified was missing or invalid"}
+	}}
+
+	r := s.Send(bulkPeers(10), map[string]string{"message": "hi"})
+	if calls != 1 {
+		t.Fatal("permanent error retried:", calls)
+	}
+	if r.Failed != 10 || r.Errors["100: One of the parameters specified was missing or invalid"] != 10 {
+		t.Fatal(r.Errors)
+	}
+}
+
+func TestBulkSenderNoAnswer(t *testing.T) {
+	var calls int
+	s := &BulkSender{RetryDelay: time.Millisecond, RatePerSecond: 1000, SendBatch: func(p map[string]string) ([]MessagesSendPeerAns, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection reset by peer")
+		}
+		// Про получателя 3 ВК ничего не ответил
+		return []MessagesSendPeerAns{{PeerID: 1, MessageID: 10}, {PeerID: 2, MessageID: 20}}, nil
+	}}
+
+	r := s.Send(bulkPeers(3), map[string]string{"message": "hi"})
+	if r.Sent != 2 || r.Failed != 1 || r.FailedPeers[3] != BulkNoAnswer {
+		t.Fatal(r.Sent, r.FailedPeers)
+	}
+}
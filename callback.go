@@ -12,9 +12,12 @@ type CallBackObj struct {
 	Object  json.RawMessage `json:"object"`
 	GroupID int             `json:"group_id"`
 	Secret  string          `json:"secret"`
+	EventID string          `json:"event_id"`
+	V       string          `json:"v"`
 
 	Message        MessagesGetAns         `json:"-"`
 	MessageAllow   CallbackMessageAllow   `json:"-"`
+	MessageEvent   CallbackMessageEvent   `json:"-"`
 	Photo          PhotosGetItem          `json:"-"`
 	PhotoComment   WallGetCommentsItem    `json:"-"`
 	Video          VideoGetItem           `json:"-"`
@@ -44,6 +47,8 @@ func (cbo *CallBackObj) Parse() (err error) {
 		err = json.Unmarshal(cbo.Object, &cbo.Message)
 	case "message_allow", "message_deny":
 		err = json.Unmarshal(cbo.Object, &cbo.MessageAllow)
+	case "message_event":
+		err = json.Unmarshal(cbo.Object, &cbo.MessageEvent)
 	case "photo_new":
 		err = json.Unmarshal(cbo.Object, &cbo.Photo)
 	case "photo_comment_new", "photo_comment_edit", "photo_comment_restore":
@@ -99,6 +104,15 @@ type CallbackMessageAllow struct {
 	Key    string `json:"key"`
 }
 
+// CallbackMessageEvent - объект нажатия callback кнопки
+type CallbackMessageEvent struct {
+	UserID                int             `json:"user_id"`
+	PeerID                int             `json:"peer_id"`
+	EventID               string          `json:"event_id"`
+	Payload               json.RawMessage `json:"payload"`
+	ConversationMessageID int             `json:"conversation_message_id"`
+}
+
 // CallbackCommentDelete - объект инфы о удаленной фотке
 type CallbackCommentDelete struct {
 	OwnerID      int `json:"owner_id"`
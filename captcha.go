@@ -0,0 +1,50 @@
+package vkapi
+
+import "log"
+
+const (
+	// CaptchaMaxAttempts - сколько раз подряд пробуем решить капчу для одного запроса
+	CaptchaMaxAttempts = 3
+)
+
+// CaptchaError - ВК просит ввести капчу (ошибка 14)
+type CaptchaError struct {
+	Method string
+	Sid    string
+	Img    string
+	msg    string
+}
+
+func (e *CaptchaError) Error() string {
+	return e.msg
+}
+
+// CaptchaSolver - решение капчи, возвращает текст с картинки e.Img
+type CaptchaSolver interface {
+	Solve(e *CaptchaError) (key string, err error)
+}
+
+// CaptchaSolverFunc - функция как CaptchaSolver
+type CaptchaSolverFunc func(e *CaptchaError) (key string, err error)
+
+// Solve - вызываем функцию
+func (f CaptchaSolverFunc) Solve(e *CaptchaError) (key string, err error) {
+	return f(e)
+}
+
+// Решаем капчу, без решателя или после CaptchaMaxAttempts попыток возвращаем *CaptchaError
+func (vk *API) solveCaptcha(method string, rerr ResponseError, attempt int) (key string, err error) {
+	cerr := &CaptchaError{Method: method, Sid: rerr.CaptchaSid, Img: rerr.CaptchaImg, msg: rerr.ErrorMsg}
+	if vk.Captcha == nil || attempt > CaptchaMaxAttempts {
+		err = cerr
+		return
+	}
+
+	key, err = vk.Captcha.Solve(cerr)
+	if err != nil {
+		log.Println("[error]", method, err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,89 @@
+package vkapi
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// CallbackDedupTTL - сколько помним обработанное событие по умолчанию
+	CallbackDedupTTL = 10 * time.Minute
+)
+
+// CallbackDedupStore - хранилище обработанных событий callback
+// Реализация для внешнего KV (redis и т.п.) должна делать Add атомарно (SET NX EX)
+type CallbackDedupStore interface {
+	// Add - добавляем ключ, added = false если ключ уже был
+	Add(key string, ttl time.Duration) (added bool, err error)
+	// Delete - удаляем ключ, чтобы событие можно было обработать повторно
+	Delete(key string) (err error)
+}
+
+// MemoryDedupStore - хранилище событий в памяти с TTL
+type MemoryDedupStore struct {
+	h         map[string]time.Time
+	lastClean time.Time
+	sync.Mutex
+}
+
+// NewMemoryDedupStore - создаем хранилище событий в памяти
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{h: make(map[string]time.Time)}
+}
+
+// Add - добавляем ключ, added = false если ключ уже был и не протух
+func (s *MemoryDedupStore) Add(key string, ttl time.Duration) (added bool, err error) {
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Периодически чистим протухшие ключи
+	if now.Sub(s.lastClean) > time.Minute {
+		for k, exp := range s.h {
+			if now.After(exp) {
+				delete(s.h, k)
+			}
+		}
+		s.lastClean = now
+	}
+
+	if exp, ok := s.h[key]; ok && now.Before(exp) {
+		return
+	}
+
+	s.h[key] = now.Add(ttl)
+	added = true
+	return
+}
+
+// Delete - удаляем ключ
+func (s *MemoryDedupStore) Delete(key string) (err error) {
+	s.Lock()
+	delete(s.h, key)
+	s.Unlock()
+	return
+}
+
+// Len - кол-во ключей в хранилище
+func (s *MemoryDedupStore) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.h)
+}
+
+// DedupKey - ключ события для дедупликации
+// Старые версии API не присылают event_id, тогда берем хэш от содержимого события
+func (cbo *CallBackObj) DedupKey() string {
+	if cbo.EventID != "" {
+		return cbo.EventID
+	}
+
+	h := sha1.New()
+	h.Write([]byte(strconv.Itoa(cbo.GroupID) + "_" + cbo.Type + "_"))
+	h.Write(cbo.Object)
+	return hex.EncodeToString(h.Sum(nil))
+}
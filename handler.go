@@ -0,0 +1,113 @@
+package vkapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// CallbackHandler - http обработчик запросов callback сервера
+type CallbackHandler struct {
+	// ConfirmationCodes - коды подтверждения сервера по группам
+	ConfirmationCodes map[int]string
+	// Secrets - секретные ключи по группам, если nil то не проверяем
+	// События групп без ключа отклоняются, если не задан Secrets.AllowUnconfigured
+	Secrets *CallbackSecrets
+	// Dedup - хранилище обработанных событий, если nil то повторы не отсеиваются
+	Dedup    CallbackDedupStore
+	DedupTTL time.Duration
+	// Queue - очередь для отложенной обработки, если задана то OnEvent не вызывается
+	Queue CallbackQueue
+	// OnEvent - обработчик события, при ошибке ВК повторит доставку
+	OnEvent func(cbo *CallBackObj) error
+}
+
+// ServeHTTP - обрабатываем запрос от ВК
+func (h *CallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Println("[error]", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var cbo CallBackObj
+	err = json.Unmarshal(content, &cbo)
+	if err != nil {
+		log.Println("[error]", err, string(content))
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if cbo.Type == "confirmation" {
+		w.Write([]byte(h.ConfirmationCodes[cbo.GroupID]))
+		return
+	}
+
+	if h.Secrets != nil && !h.Secrets.Check(cbo.GroupID, cbo.Secret) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	err = h.Handle(&cbo)
+	if err != nil {
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+// Handle - обрабатываем событие, повторные доставки пропускаем
+func (h *CallbackHandler) Handle(cbo *CallBackObj) (err error) {
+	if promInited {
+		promCallbackCount.WithLabelValues(cbo.Type).Inc()
+	}
+
+	var key string
+	if h.Dedup != nil {
+		ttl := h.DedupTTL
+		if ttl == 0 {
+			ttl = CallbackDedupTTL
+		}
+
+		key = cbo.DedupKey()
+		added, derr := h.Dedup.Add(key, ttl)
+		if derr != nil {
+			// Хранилище недоступно - лучше обработать дважды чем потерять событие
+			log.Println("[error]", derr)
+			key = ""
+		} else if !added {
+			if promInited {
+				promCallbackDup.WithLabelValues(cbo.Type).Inc()
+			}
+			return
+		}
+	}
+
+	if h.Queue != nil {
+		// Обработчики очереди сами распарсят событие
+		err = h.Queue.Push(*cbo)
+		if err != nil {
+			log.Println("[error]", err)
+		}
+	} else {
+		// Если не распарсили то повтор не поможет, ошибка уже залогирована
+		if cbo.Parse() != nil || h.OnEvent == nil {
+			return
+		}
+
+		err = h.OnEvent(cbo)
+	}
+
+	if err != nil && key != "" {
+		// Даем ВК доставить событие повторно
+		if derr := h.Dedup.Delete(key); derr != nil {
+			log.Println("[error]", derr)
+		}
+	}
+
+	return
+}
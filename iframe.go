@@ -1,21 +1,51 @@
 package vkapi
 
 import (
+	"context"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 )
 
 var (
 	iframeCheckSignReq *regexp.Regexp
+
+	// ErrIframeAuthKeyInvalid - auth_key не совпадает
+	ErrIframeAuthKeyInvalid = errors.New("iframe auth_key is invalid")
+	// ErrIframeSignInvalid - sign не совпадает, параметрам запуска доверять нельзя
+	ErrIframeSignInvalid = errors.New("iframe sign is invalid")
 )
 
 func init() {
 	iframeCheckSignReq = regexp.MustCompile("(?:^|&)([a-z0-9_]+)=")
 }
 
+type iframeViewerKey struct{}
+
+// IframeViewer - проверенные по auth_key и sign параметры запуска iframe приложения
+type IframeViewer struct {
+	APIID    int
+	ViewerID int
+	// ViewerType - роль в сообществе, из которого запущено приложение
+	ViewerType  int
+	UserID      int
+	GroupID     int
+	IsAppUser   bool
+	AccessToken string
+	Language    string
+	Referrer    string
+	// APIResult - результат запроса из настроек приложения, см. DecodeAPIResult
+	// В sign не входит, поэтому доверять ему нельзя
+	APIResult string
+}
+
 // IframeCheckSign - Проверка подписи ВК
 func IframeCheckSign(r *http.Request, secret string) (ok bool) {
 	var sign string
@@ -40,3 +70,79 @@ func IframeCheckSign(r *http.Request, secret string) (ok bool) {
 	ok = true
 	return
 }
+
+// IframeCheckAuthKey - проверяем auth_key = md5(api_id_viewer_id_api_secret)
+func IframeCheckAuthKey(apiID, viewerID int, secret, authKey string) bool {
+	h := md5.Sum([]byte(strconv.Itoa(apiID) + "_" + strconv.Itoa(viewerID) + "_" + secret))
+	return hmac.Equal([]byte(hex.EncodeToString(h[:])), []byte(authKey))
+}
+
+// IframeParseViewer - проверяем auth_key и sign и разбираем параметры запуска
+// auth_key подтверждает только api_id и viewer_id, остальное подтверждает sign
+func IframeParseViewer(r *http.Request, secret string) (v IframeViewer, err error) {
+	v.APIID, _ = strconv.Atoi(r.FormValue("api_id"))
+	v.ViewerID, _ = strconv.Atoi(r.FormValue("viewer_id"))
+
+	if v.ViewerID == 0 || !IframeCheckAuthKey(v.APIID, v.ViewerID, secret, r.FormValue("auth_key")) {
+		err = ErrIframeAuthKeyInvalid
+		return
+	}
+
+	if !IframeCheckSign(r, secret) {
+		v = IframeViewer{}
+		err = ErrIframeSignInvalid
+		return
+	}
+
+	v.ViewerType, _ = strconv.Atoi(r.FormValue("viewer_type"))
+	v.UserID, _ = strconv.Atoi(r.FormValue("user_id"))
+	v.GroupID, _ = strconv.Atoi(r.FormValue("group_id"))
+	v.IsAppUser = r.FormValue("is_app_user") == "1"
+	v.AccessToken = r.FormValue("access_token")
+	v.Language = r.FormValue("language")
+	v.Referrer = r.FormValue("referrer")
+	v.APIResult = r.FormValue("api_result")
+	return
+}
+
+// DecodeAPIResult - разбираем api_result в объект ответа метода
+func (v *IframeViewer) DecodeAPIResult(ans interface{}) (err error) {
+	var r Response
+	err = json.Unmarshal([]byte(v.APIResult), &r)
+	if err != nil {
+		log.Println("[error]", err, v.APIResult)
+		return
+	}
+
+	if r.Error.ErrorCode != 0 {
+		err = errors.New(r.Error.ErrorMsg)
+		return
+	}
+
+	err = json.Unmarshal(r.Response, ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// IframeAuth - пропускаем запрос только с верными auth_key и sign, проверенный пользователь кладется в контекст
+func IframeAuth(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, err := IframeParseViewer(r, secret)
+		if err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), iframeViewerKey{}, v)))
+	})
+}
+
+// IframeViewerFromContext - пользователь, проверенный IframeAuth
+func IframeViewerFromContext(ctx context.Context) (v IframeViewer, ok bool) {
+	v, ok = ctx.Value(iframeViewerKey{}).(IframeViewer)
+	return
+}
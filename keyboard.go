@@ -0,0 +1,356 @@
+package vkapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"unicode/utf8"
+)
+
+// Ограничения ВК для клавиатур и шаблонов
+const (
+	KeyboardMaxRows           = 10
+	KeyboardInlineMaxRows     = 6
+	KeyboardMaxButtonsInRow   = 5
+	KeyboardMaxButtons        = 40
+	KeyboardInlineMaxButtons  = 10
+	KeyboardMaxPayloadLen     = 255
+	KeyboardMaxLabelLen       = 40
+	CarouselMaxElements       = 10
+	CarouselMaxButtons        = 3
+	CarouselMaxTitleLen       = 80
+	CarouselMaxDescriptionLen = 80
+)
+
+// Цвета кнопок
+const (
+	ButtonColorPrimary   = "primary"
+	ButtonColorSecondary = "secondary"
+	ButtonColorNegative  = "negative"
+	ButtonColorPositive  = "positive"
+)
+
+// Keyboard - клавиатура бота
+type Keyboard struct {
+	OneTime bool       `json:"one_time,omitempty"`
+	Inline  bool       `json:"inline,omitempty"`
+	Buttons [][]Button `json:"buttons"`
+}
+
+// Button - кнопка клавиатуры
+type Button struct {
+	Action ButtonAction `json:"action"`
+	Color  string       `json:"color,omitempty"`
+}
+
+// ButtonAction - действие кнопки
+type ButtonAction struct {
+	Type    string `json:"type"`
+	Label   string `json:"label,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	AppID   int    `json:"app_id,omitempty"`
+	OwnerID int    `json:"owner_id,omitempty"`
+}
+
+// NewKeyboard - создаем обычную клавиатуру
+func NewKeyboard(oneTime bool) *Keyboard {
+	return &Keyboard{OneTime: oneTime, Buttons: [][]Button{}}
+}
+
+// NewInlineKeyboard - создаем клавиатуру внутри сообщения
+func NewInlineKeyboard() *Keyboard {
+	return &Keyboard{Inline: true, Buttons: [][]Button{}}
+}
+
+// AddRow - добавляем ряд кнопок
+func (k *Keyboard) AddRow(buttons ...Button) *Keyboard {
+	k.Buttons = append(k.Buttons, buttons)
+	return k
+}
+
+// TextButton - кнопка отправляющая текст
+func TextButton(label, payload, color string) Button {
+	return Button{Action: ButtonAction{Type: "text", Label: label, Payload: payload}, Color: color}
+}
+
+// CallbackButton - кнопка присылающая событие message_event
+func CallbackButton(label, payload, color string) Button {
+	return Button{Action: ButtonAction{Type: "callback", Label: label, Payload: payload}, Color: color}
+}
+
+// OpenLinkButton - кнопка открывающая ссылку
+func OpenLinkButton(label, link, payload string) Button {
+	return Button{Action: ButtonAction{Type: "open_link", Label: label, Link: link, Payload: payload}}
+}
+
+// LocationButton - кнопка отправки местоположения
+func LocationButton(payload string) Button {
+	return Button{Action: ButtonAction{Type: "location", Payload: payload}}
+}
+
+// VKPayButton - кнопка оплаты VK Pay
+func VKPayButton(hash, payload string) Button {
+	return Button{Action: ButtonAction{Type: "vkpay", Hash: hash, Payload: payload}}
+}
+
+// OpenAppButton - кнопка открывающая VK Mini App
+func OpenAppButton(label string, appID, ownerID int, hash, payload string) Button {
+	return Button{Action: ButtonAction{Type: "open_app", Label: label, AppID: appID, OwnerID: ownerID, Hash: hash, Payload: payload}}
+}
+
+// Validate - проверяем кнопку
+func (b Button) Validate() (err error) {
+	a := b.Action
+
+	if len(a.Payload) > KeyboardMaxPayloadLen {
+		return fmt.Errorf("button payload is longer than %d", KeyboardMaxPayloadLen)
+	}
+	if a.Payload != "" && !json.Valid([]byte(a.Payload)) {
+		return errors.New("button payload is not valid json")
+	}
+	if utf8.RuneCountInString(a.Label) > KeyboardMaxLabelLen {
+		return fmt.Errorf("button label is longer than %d", KeyboardMaxLabelLen)
+	}
+
+	switch a.Type {
+	case "text", "callback":
+		if a.Label == "" {
+			return errors.New(a.Type + " button without label")
+		}
+		switch b.Color {
+		case "", ButtonColorPrimary, ButtonColorSecondary, ButtonColorNegative, ButtonColorPositive:
+		default:
+			return errors.New("unknown button color: " + b.Color)
+		}
+		return
+	case "open_link":
+		if a.Label == "" || a.Link == "" {
+			return errors.New("open_link button needs label and link")
+		}
+	case "location":
+	case "vkpay":
+		if a.Hash == "" {
+			return errors.New("vkpay button without hash")
+		}
+	case "open_app":
+		if a.Label == "" || a.AppID == 0 {
+			return errors.New("open_app button needs label and app_id")
+		}
+	default:
+		return errors.New("unknown button type: " + a.Type)
+	}
+
+	if b.Color != "" {
+		return errors.New(a.Type + " button can not have color")
+	}
+
+	return
+}
+
+// Validate - проверяем ограничения ВК
+func (k *Keyboard) Validate() (err error) {
+	maxRows, maxButtons := KeyboardMaxRows, KeyboardMaxButtons
+	if k.Inline {
+		maxRows, maxButtons = KeyboardInlineMaxRows, KeyboardInlineMaxButtons
+		if k.OneTime {
+			return errors.New("inline keyboard can not be one_time")
+		}
+	}
+
+	if len(k.Buttons) > maxRows {
+		return fmt.Errorf("keyboard has %d rows, max %d", len(k.Buttons), maxRows)
+	}
+
+	var total int
+	for i, row := range k.Buttons {
+		if len(row) == 0 {
+			return fmt.Errorf("keyboard row %d is empty", i)
+		}
+		if len(row) > KeyboardMaxButtonsInRow {
+			return fmt.Errorf("keyboard row %d has %d buttons, max %d", i, len(row), KeyboardMaxButtonsInRow)
+		}
+
+		for j := range row {
+			err = row[j].Validate()
+			if err != nil {
+				return fmt.Errorf("keyboard button %d:%d: %s", i, j, err)
+			}
+
+			switch row[j].Action.Type {
+			case "location", "vkpay", "open_app":
+				if len(row) > 1 {
+					return fmt.Errorf("keyboard button %d:%d: %s must be alone in a row", i, j, row[j].Action.Type)
+				}
+			}
+		}
+
+		total += len(row)
+	}
+
+	if total > maxButtons {
+		return fmt.Errorf("keyboard has %d buttons, max %d", total, maxButtons)
+	}
+
+	return
+}
+
+// JSON - проверяем и сериализуем клавиатуру
+func (k *Keyboard) JSON() (str string, err error) {
+	err = k.Validate()
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	b, err := json.Marshal(k)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	str = string(b)
+	return
+}
+
+// ToParams - добавляем клавиатуру в параметры MessagesSend
+func (k *Keyboard) ToParams(params map[string]string) (err error) {
+	str, err := k.JSON()
+	if err != nil {
+		return
+	}
+
+	params["keyboard"] = str
+	return
+}
+
+/*
+	Карусель
+*/
+
+// Carousel - шаблон сообщения карусель
+type Carousel struct {
+	Type     string            `json:"type"`
+	Elements []CarouselElement `json:"elements"`
+}
+
+// CarouselElement - элемент карусели
+type CarouselElement struct {
+	Title       string          `json:"title,omitempty"`
+	Description string          `json:"description,omitempty"`
+	PhotoID     string          `json:"photo_id,omitempty"`
+	Action      *CarouselAction `json:"action,omitempty"`
+	Buttons     []Button        `json:"buttons"`
+}
+
+// CarouselAction - действие при нажатии на элемент карусели
+type CarouselAction struct {
+	Type string `json:"type"`
+	Link string `json:"link,omitempty"`
+}
+
+// NewCarousel - создаем карусель
+func NewCarousel() *Carousel {
+	return &Carousel{Type: "carousel", Elements: []CarouselElement{}}
+}
+
+// AddElement - добавляем элемент карусели
+func (c *Carousel) AddElement(e CarouselElement) *Carousel {
+	c.Elements = append(c.Elements, e)
+	return c
+}
+
+// CarouselOpenLink - при нажатии на элемент открываем ссылку
+func CarouselOpenLink(link string) *CarouselAction {
+	return &CarouselAction{Type: "open_link", Link: link}
+}
+
+// CarouselOpenPhoto - при нажатии на элемент открываем фото
+func CarouselOpenPhoto() *CarouselAction {
+	return &CarouselAction{Type: "open_photo"}
+}
+
+// Validate - проверяем ограничения ВК
+// Все элементы карусели должны быть одинаково устроены: с фото или без, с одинаковым числом кнопок
+func (c *Carousel) Validate() (err error) {
+	if len(c.Elements) == 0 {
+		return errors.New("carousel without elements")
+	}
+	if len(c.Elements) > CarouselMaxElements {
+		return fmt.Errorf("carousel has %d elements, max %d", len(c.Elements), CarouselMaxElements)
+	}
+
+	first := c.Elements[0]
+	for i, e := range c.Elements {
+		if e.Title == "" && e.PhotoID == "" {
+			return fmt.Errorf("carousel element %d needs title or photo", i)
+		}
+		if utf8.RuneCountInString(e.Title) > CarouselMaxTitleLen {
+			return fmt.Errorf("carousel element %d title is longer than %d", i, CarouselMaxTitleLen)
+		}
+		if utf8.RuneCountInString(e.Description) > CarouselMaxDescriptionLen {
+			return fmt.Errorf("carousel element %d description is longer than %d", i, CarouselMaxDescriptionLen)
+		}
+		if len(e.Buttons) == 0 || len(e.Buttons) > CarouselMaxButtons {
+			return fmt.Errorf("carousel element %d must have 1-%d buttons", i, CarouselMaxButtons)
+		}
+		if len(e.Buttons) != len(first.Buttons) || (e.PhotoID == "") != (first.PhotoID == "") || (e.Title == "") != (first.Title == "") {
+			return fmt.Errorf("carousel element %d differs from the first element", i)
+		}
+
+		if e.Action != nil {
+			switch e.Action.Type {
+			case "open_link":
+				if e.Action.Link == "" {
+					return fmt.Errorf("carousel element %d open_link without link", i)
+				}
+			case "open_photo":
+				if e.PhotoID == "" {
+					return fmt.Errorf("carousel element %d open_photo without photo", i)
+				}
+			default:
+				return fmt.Errorf("carousel element %d unknown action %s", i, e.Action.Type)
+			}
+		}
+
+		for j := range e.Buttons {
+			err = e.Buttons[j].Validate()
+			if err != nil {
+				return fmt.Errorf("carousel element %d button %d: %s", i, j, err)
+			}
+		}
+	}
+
+	return
+}
+
+// JSON - проверяем и сериализуем карусель
+func (c *Carousel) JSON() (str string, err error) {
+	err = c.Validate()
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	str = string(b)
+	return
+}
+
+// ToParams - добавляем карусель в параметры MessagesSend
+func (c *Carousel) ToParams(params map[string]string) (err error) {
+	str, err := c.JSON()
+	if err != nil {
+		return
+	}
+
+	params["template"] = str
+	return
+}
@@ -0,0 +1,101 @@
+package vkapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrLaunchSignInvalid - подпись параметров запуска неверна
+	ErrLaunchSignInvalid = errors.New("launch params sign is invalid")
+	// ErrLaunchParamsExpired - параметры запуска устарели
+	ErrLaunchParamsExpired = errors.New("launch params are expired")
+)
+
+// LaunchParams - параметры запуска VK Mini Apps
+type LaunchParams struct {
+	UserID  int
+	AppID   int
+	GroupID int
+	// ViewerGroupRole - роль в сообществе: none, member, moder, editor, admin
+	ViewerGroupRole string
+	// Platform - mobile_android, mobile_iphone, desktop_web, mobile_web...
+	Platform                string
+	Language                string
+	Ref                     string
+	AreNotificationsEnabled bool
+	IsAppUser               bool
+	IsFavorite              bool
+	AccessTokenSettings     string
+	Ts                      time.Time
+	// Raw - все vk_* параметры
+	Raw url.Values
+}
+
+// ParseLaunchParams - проверяем подпись параметров запуска (строка запроса, можно с ?) и разбираем их
+// maxAge - насколько старыми могут быть параметры по vk_ts, 0 - не проверяем
+func ParseLaunchParams(query, secret string, maxAge time.Duration) (p LaunchParams, err error) {
+	q, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	if err != nil {
+		return
+	}
+
+	vkParams := url.Values{}
+	for k, v := range q {
+		if strings.HasPrefix(k, "vk_") && len(v) > 0 {
+			vkParams.Set(k, v[0])
+		}
+	}
+
+	// Параметры сортируются по ключу, Encode делает это сам
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(vkParams.Encode()))
+	sign := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+
+	if !hmac.Equal([]byte(sign), []byte(strings.TrimRight(q.Get("sign"), "="))) {
+		err = ErrLaunchSignInvalid
+		return
+	}
+
+	p = LaunchParams{
+		ViewerGroupRole:         vkParams.Get("vk_viewer_group_role"),
+		Platform:                vkParams.Get("vk_platform"),
+		Language:                vkParams.Get("vk_language"),
+		Ref:                     vkParams.Get("vk_ref"),
+		AreNotificationsEnabled: vkParams.Get("vk_are_notifications_enabled") == "1",
+		IsAppUser:               vkParams.Get("vk_is_app_user") == "1",
+		IsFavorite:              vkParams.Get("vk_is_favorite") == "1",
+		AccessTokenSettings:     vkParams.Get("vk_access_token_settings"),
+		Raw:                     vkParams,
+	}
+	p.UserID, _ = strconv.Atoi(vkParams.Get("vk_user_id"))
+	p.AppID, _ = strconv.Atoi(vkParams.Get("vk_app_id"))
+	p.GroupID, _ = strconv.Atoi(vkParams.Get("vk_group_id"))
+
+	if ts, cerr := strconv.ParseInt(vkParams.Get("vk_ts"), 10, 64); cerr == nil {
+		p.Ts = time.Unix(ts, 0)
+	}
+	if maxAge > 0 && (p.Ts.IsZero() || time.Since(p.Ts) > maxAge) {
+		err = ErrLaunchParamsExpired
+		return
+	}
+
+	return
+}
+
+// LaunchParamsFromRequest - параметры запуска из заголовка X-Launch-Params или строки запроса
+func LaunchParamsFromRequest(r *http.Request, secret string, maxAge time.Duration) (p LaunchParams, err error) {
+	query := r.Header.Get("X-Launch-Params")
+	if query == "" {
+		query = r.URL.RawQuery
+	}
+
+	return ParseLaunchParams(query, secret, maxAge)
+}
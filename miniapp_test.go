@@ -0,0 +1,46 @@
+package vkapi
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Пример из документации ВК по проверке подписи параметров запуска
+const (
+	launchParamsExample       = "vk_user_id=494075&vk_app_id=6736218&vk_is_app_user=1&vk_are_notifications_enabled=1&vk_language=ru&vk_access_token_settings=&vk_platform=android&sign=htQFduJpLxz7ribXRZpDFUH-XEUhC9rBPTJkjUFEkRA"
+	launchParamsExampleSecret = "wvl68m4dR1UpLrVRli"
+)
+
+func TestParseLaunchParams(t *testing.T) {
+	p, err := ParseLaunchParams("?"+launchParamsExample, launchParamsExampleSecret, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != 494075 || p.AppID != 6736218 || !p.IsAppUser || !p.AreNotificationsEnabled || p.Language != "ru" || p.Platform != "android" {
+		t.Fatal(p)
+	}
+
+	if _, err = ParseLaunchParams(launchParamsExample, "bad", 0); err != ErrLaunchSignInvalid {
+		t.Fatal(err)
+	}
+	if _, err = ParseLaunchParams(strings.Replace(launchParamsExample, "vk_user_id=494075", "vk_user_id=1", 1), launchParamsExampleSecret, 0); err != ErrLaunchSignInvalid {
+		t.Fatal("changed user accepted:", err)
+	}
+	// В примере нет vk_ts - с проверкой возраста параметры не принимаются
+	if _, err = ParseLaunchParams(launchParamsExample, launchParamsExampleSecret, time.Minute); err != ErrLaunchParamsExpired {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set("X-Launch-Params", launchParamsExample)
+	if p, err = LaunchParamsFromRequest(r, launchParamsExampleSecret, 0); err != nil || p.UserID != 494075 {
+		t.Fatal(p, err)
+	}
+
+	r = httptest.NewRequest("GET", "/?"+launchParamsExample, nil)
+	if p, err = LaunchParamsFromRequest(r, launchParamsExampleSecret, 0); err != nil || p.AppID != 6736218 {
+		t.Fatal(p, err)
+	}
+}
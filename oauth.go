@@ -0,0 +1,262 @@
+package vkapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrOAuthStateInvalid - state не совпадает или подпись неверна
+	ErrOAuthStateInvalid = errors.New("oauth state is invalid")
+	// ErrOAuthStateExpired - state устарел
+	ErrOAuthStateExpired = errors.New("oauth state is expired")
+)
+
+/*
+	Ошибки
+*/
+
+// OAuthError - ошибка авторизации (error и error_description)
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	// RedirectURI, ValidationType, PhoneMask - для need_validation (двухфакторная авторизация)
+	RedirectURI    string `json:"redirect_uri"`
+	ValidationType string `json:"validation_type"`
+	PhoneMask      string `json:"phone_mask"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// IsAccessDenied - пользователь отказался выдавать права
+func (e *OAuthError) IsAccessDenied() bool {
+	return e.Code == "access_denied"
+}
+
+// NeedValidation - нужна проверка пользователем (2fa_sms, 2fa_app), пользователя надо отправить на RedirectURI
+func (e *OAuthError) NeedValidation() bool {
+	return e.Code == "need_validation"
+}
+
+// Ошибка из ответа сервера авторизации, nil если ошибки нет
+func parseOAuthError(content []byte) *OAuthError {
+	var e OAuthError
+	if json.Unmarshal(content, &e) != nil || e.Code == "" {
+		return nil
+	}
+	return &e
+}
+
+/*
+	Токены сообществ
+*/
+
+// Ответ бывает вида {"access_token_1":"...","expires_in":0} или {"groups":[{"group_id":1,"access_token":"..."}]}
+func parseTokenGroupAns(content []byte) (ans GetTokenGroupAns, err error) {
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(content, &m)
+	if err != nil {
+		return
+	}
+
+	ans.Tokens = make(map[int]string)
+	for k, v := range m {
+		switch k {
+		case "expires_in":
+			json.Unmarshal(v, &ans.ExpiresIn)
+		case "error":
+			json.Unmarshal(v, &ans.Error)
+		case "error_description":
+			json.Unmarshal(v, &ans.ErrorDescription)
+		case "groups":
+			var groups []struct {
+				GroupID     int    `json:"group_id"`
+				AccessToken string `json:"access_token"`
+			}
+			err = json.Unmarshal(v, &groups)
+			if err != nil {
+				return
+			}
+			for _, g := range groups {
+				ans.Tokens[g.GroupID] = g.AccessToken
+			}
+		default:
+			if r := GroupAccessTokenReg.FindStringSubmatch(k); r != nil {
+				id, _ := strconv.Atoi(r[1])
+				var token string
+				json.Unmarshal(v, &token)
+				ans.Tokens[id] = token
+			}
+		}
+	}
+
+	return
+}
+
+/*
+	Implicit flow
+*/
+
+// ImplicitTokenAns - токен из фрагмента redirect_uri (response_type=token)
+type ImplicitTokenAns struct {
+	AccessToken string
+	ExpiresIn   int
+	UserID      int
+	Email       string
+	State       string
+	// GroupTokens - токены сообществ, если запрашивались group_ids
+	GroupTokens map[int]string
+}
+
+// ParseImplicitToken - разбираем фрагмент вида access_token=...&expires_in=86400&user_id=1 (можно с # в начале)
+func ParseImplicitToken(fragment string) (ans ImplicitTokenAns, err error) {
+	q, err := url.ParseQuery(strings.TrimPrefix(fragment, "#"))
+	if err != nil {
+		return
+	}
+
+	if q.Get("error") != "" {
+		err = &OAuthError{Code: q.Get("error"), Description: q.Get("error_description")}
+		return
+	}
+
+	ans.AccessToken = q.Get("access_token")
+	ans.ExpiresIn, _ = strconv.Atoi(q.Get("expires_in"))
+	ans.UserID, _ = strconv.Atoi(q.Get("user_id"))
+	ans.Email = q.Get("email")
+	ans.State = q.Get("state")
+
+	for k := range q {
+		if r := GroupAccessTokenReg.FindStringSubmatch(k); r != nil {
+			if ans.GroupTokens == nil {
+				ans.GroupTokens = make(map[int]string)
+			}
+			id, _ := strconv.Atoi(r[1])
+			ans.GroupTokens[id] = q.Get(k)
+		}
+	}
+
+	if ans.AccessToken == "" && len(ans.GroupTokens) == 0 {
+		err = errors.New("no access_token in fragment")
+		return
+	}
+
+	return
+}
+
+/*
+	PKCE и state
+*/
+
+// PKCE - пара verifier/challenge, challenge уходит в ссылку авторизации, verifier - при обмене code
+type PKCE struct {
+	Verifier  string
+	Challenge string
+}
+
+// NewPKCE - генерируем новую пару
+func NewPKCE() (p PKCE, err error) {
+	p.Verifier, err = randomURLString(32)
+	if err != nil {
+		return
+	}
+	p.Challenge = PKCEChallenge(p.Verifier)
+	return
+}
+
+// PKCEChallenge - challenge по методу S256
+func PKCEChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// VerifyPKCE - проверяем что verifier соответствует challenge
+func VerifyPKCE(verifier, challenge string) bool {
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// NewOAuthState - случайный state для хранения на своей стороне (в сессии, кэше)
+func NewOAuthState() (string, error) {
+	return randomURLString(24)
+}
+
+// SignOAuthState - state с подписью, не требует хранения: payload.время.nonce.подпись
+// В payload можно передать, например, куда вернуть пользователя после авторизации
+func SignOAuthState(secret []byte, payload string) (state string, err error) {
+	nonce, err := randomURLString(12)
+	if err != nil {
+		return
+	}
+
+	state = base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + strconv.FormatInt(time.Now().Unix(), 10) + "." + nonce
+	state += "." + oauthStateSign(secret, state)
+	return
+}
+
+// VerifyOAuthState - проверяем подпись и возраст state, возвращаем payload
+func VerifyOAuthState(secret []byte, state string, maxAge time.Duration) (payload string, err error) {
+	i := strings.LastIndex(state, ".")
+	if i == -1 {
+		err = ErrOAuthStateInvalid
+		return
+	}
+
+	if !hmac.Equal([]byte(oauthStateSign(secret, state[:i])), []byte(state[i+1:])) {
+		err = ErrOAuthStateInvalid
+		return
+	}
+
+	arr := strings.Split(state[:i], ".")
+	if len(arr) != 3 {
+		err = ErrOAuthStateInvalid
+		return
+	}
+
+	ts, err := strconv.ParseInt(arr[1], 10, 64)
+	if err != nil {
+		err = ErrOAuthStateInvalid
+		return
+	}
+	if maxAge > 0 && time.Since(time.Unix(ts, 0)) > maxAge {
+		err = ErrOAuthStateExpired
+		return
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(arr[0])
+	if err != nil {
+		err = ErrOAuthStateInvalid
+		return
+	}
+
+	payload = string(b)
+	return
+}
+
+func oauthStateSign(secret []byte, str string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(str))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func randomURLString(n int) (str string, err error) {
+	b := make([]byte, n)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
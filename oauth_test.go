@@ -0,0 +1,42 @@
+package vkapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetTokenError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"Code is invalid or expired."}`))
+	}))
+	defer srv.Close()
+
+	vk := &API{}
+	ans, err := vk.GetToken(TokenData{Code: "c", TokenURL: srv.URL})
+	oerr, ok := err.(*OAuthError)
+	if !ok || oerr.Code != "invalid_grant" {
+		t.Fatal(err)
+	}
+	// Старые проверки ans.Error продолжают работать
+	if ans.Error != "invalid_grant" || ans.ErrorDescription == "" {
+		t.Fatal(ans)
+	}
+}
+
+func TestOAuthStateSign(t *testing.T) {
+	secret := []byte("secret")
+	state, err := SignOAuthState(secret, "/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := VerifyOAuthState(secret, state, time.Minute)
+	if err != nil || payload != "/home" {
+		t.Fatal(payload, err)
+	}
+	if _, err = VerifyOAuthState([]byte("other"), state, time.Minute); err != ErrOAuthStateInvalid {
+		t.Fatal(err)
+	}
+}
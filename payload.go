@@ -0,0 +1,159 @@
+package vkapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var (
+	// ErrNoPayload - у сообщения нет payload
+	ErrNoPayload = errors.New("no payload")
+)
+
+// CommandPayload - payload по соглашению {"cmd":"..."}
+// Кнопка "Начать" присылает {"command":"start"}, поэтому понимаем и такой вариант
+type CommandPayload struct {
+	Cmd     string `json:"cmd,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// GetCmd - команда из payload
+func (p CommandPayload) GetCmd() string {
+	if p.Cmd != "" {
+		return p.Cmd
+	}
+	return p.Command
+}
+
+// EncodePayload - сериализуем payload кнопки с проверкой размера
+func EncodePayload(v interface{}) (payload string, err error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	if len(b) > KeyboardMaxPayloadLen {
+		err = fmt.Errorf("payload is %d bytes, max %d", len(b), KeyboardMaxPayloadLen)
+		log.Println("[error]", err)
+		return
+	}
+
+	payload = string(b)
+	return
+}
+
+// SetPayload - задаем кнопке типизированный payload
+func (b *Button) SetPayload(v interface{}) (err error) {
+	payload, err := EncodePayload(v)
+	if err != nil {
+		return
+	}
+
+	b.Action.Payload = payload
+	return
+}
+
+// CommandButton - текстовая кнопка с payload {"cmd":cmd, ...args}
+func CommandButton(label, color, cmd string, args map[string]interface{}) (b Button, err error) {
+	b = TextButton(label, "", color)
+	err = b.SetPayload(commandPayloadMap(cmd, args))
+	return
+}
+
+// CommandCallbackButton - callback кнопка с payload {"cmd":cmd, ...args}
+func CommandCallbackButton(label, color, cmd string, args map[string]interface{}) (b Button, err error) {
+	b = CallbackButton(label, "", color)
+	err = b.SetPayload(commandPayloadMap(cmd, args))
+	return
+}
+
+func commandPayloadMap(cmd string, args map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(args)+1)
+	for k, v := range args {
+		m[k] = v
+	}
+	m["cmd"] = cmd
+	return m
+}
+
+// DecodePayload - разбираем payload сообщения в v
+func (m *MessagesGetAns) DecodePayload(v interface{}) (err error) {
+	if m.Payload == "" {
+		err = ErrNoPayload
+		return
+	}
+
+	return decodePayload([]byte(m.Payload), v)
+}
+
+// PayloadCommand - команда из payload сообщения, пусто если ее нет
+func (m *MessagesGetAns) PayloadCommand() string {
+	return payloadCommand([]byte(m.Payload))
+}
+
+// DecodePayload - разбираем payload нажатия callback кнопки в v
+func (e *CallbackMessageEvent) DecodePayload(v interface{}) (err error) {
+	if len(e.Payload) == 0 || string(e.Payload) == "null" {
+		err = ErrNoPayload
+		return
+	}
+
+	return decodePayload(e.Payload, v)
+}
+
+// PayloadCommand - команда из payload нажатия, пусто если ее нет
+func (e *CallbackMessageEvent) PayloadCommand() string {
+	return payloadCommand(e.Payload)
+}
+
+// PayloadCommand - команда из payload события message_new или message_event
+func (cbo *CallBackObj) PayloadCommand() string {
+	if cbo.Parse() != nil {
+		return ""
+	}
+
+	switch cbo.Type {
+	case "message_new":
+		return cbo.Message.PayloadCommand()
+	case "message_event":
+		return cbo.MessageEvent.PayloadCommand()
+	}
+	return ""
+}
+
+// Достаем команду, payload может быть не по соглашению - тогда молча пусто
+func payloadCommand(b []byte) string {
+	var p CommandPayload
+	if len(b) == 0 || json.Unmarshal(unwrapPayload(b), &p) != nil {
+		return ""
+	}
+	return p.GetCmd()
+}
+
+// Иногда payload внутри события приходит строкой с json
+func unwrapPayload(b []byte) []byte {
+	var str string
+	if json.Unmarshal(b, &str) == nil {
+		return []byte(str)
+	}
+	return b
+}
+
+func decodePayload(b []byte, v interface{}) (err error) {
+	b = unwrapPayload(b)
+	if len(b) > KeyboardMaxPayloadLen {
+		err = fmt.Errorf("payload is %d bytes, max %d", len(b), KeyboardMaxPayloadLen)
+		return
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		log.Println("[error]", err, string(b))
+		return
+	}
+
+	return
+}
@@ -21,12 +21,39 @@ var (
 		},
 		[]string{"method"},
 	)
+	promCallbackCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vk",
+			Name:      "callback_events_total",
+			Help:      "vk callback events counter",
+		},
+		[]string{"type"},
+	)
+	promCallbackDup = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vk",
+			Name:      "callback_duplicates_total",
+			Help:      "vk callback duplicate events dropped",
+		},
+		[]string{"type"},
+	)
+	promTokenChecks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vk",
+			Name:      "token_checks_total",
+			Help:      "vk token validation results",
+		},
+		[]string{"kind", "result"},
+	)
 )
 
 // InitProm - инициализация прометея
 func InitProm() {
 	prometheus.MustRegister(promRq)
 	prometheus.MustRegister(promRqCount)
+	prometheus.MustRegister(promCallbackCount)
+	prometheus.MustRegister(promCallbackDup)
+	prometheus.MustRegister(promTokenChecks)
 
 	promInited = true
 }
@@ -0,0 +1,576 @@
+package vkapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueClosed - очередь закрыта
+	ErrQueueClosed = errors.New("queue closed")
+)
+
+// CallbackQueue - очередь событий callback
+type CallbackQueue interface {
+	// Push - добавляем событие в очередь
+	Push(cbo CallBackObj) (err error)
+	// Pop - забираем событие, ждем если очередь пуста. ok = false если очередь закрыта и пуста
+	Pop() (item CallbackQueueItem, ok bool)
+	// Ack - событие обработано и больше не нужно
+	Ack(id int64) (err error)
+	// Close - закрываем очередь
+	Close() (err error)
+}
+
+// CallbackQueueItem - элемент очереди
+type CallbackQueueItem struct {
+	ID      int64       `json:"id"`
+	Event   CallBackObj `json:"event"`
+	Attempt int         `json:"-"`
+}
+
+/*
+	Очередь в памяти
+*/
+
+// MemoryCallbackQueue - очередь событий в памяти
+type MemoryCallbackQueue struct {
+	items  []CallbackQueueItem
+	lastID int64
+	closed bool
+	cond   *sync.Cond
+	sync.Mutex
+}
+
+// NewMemoryCallbackQueue - создаем очередь в памяти
+func NewMemoryCallbackQueue() *MemoryCallbackQueue {
+	q := &MemoryCallbackQueue{}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// Push - добавляем событие в очередь
+func (q *MemoryCallbackQueue) Push(cbo CallBackObj) (err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		err = ErrQueueClosed
+		return
+	}
+
+	q.lastID++
+	q.items = append(q.items, CallbackQueueItem{ID: q.lastID, Event: cbo})
+	q.cond.Signal()
+	return
+}
+
+// Добавляем уже пронумерованный элемент
+func (q *MemoryCallbackQueue) pushItem(item CallbackQueueItem) (err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		err = ErrQueueClosed
+		return
+	}
+
+	if item.ID > q.lastID {
+		q.lastID = item.ID
+	}
+	q.items = append(q.items, item)
+	q.cond.Signal()
+	return
+}
+
+// Pop - забираем событие из очереди
+func (q *MemoryCallbackQueue) Pop() (item CallbackQueueItem, ok bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if len(q.items) == 0 {
+		return
+	}
+
+	item = q.items[0]
+	q.items[0] = CallbackQueueItem{}
+	q.items = q.items[1:]
+	ok = true
+	return
+}
+
+// Ack - в памяти подтверждать нечего
+func (q *MemoryCallbackQueue) Ack(id int64) (err error) {
+	return
+}
+
+// Len - кол-во событий в очереди
+func (q *MemoryCallbackQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items)
+}
+
+// Close - закрываем очередь, оставшиеся события еще можно забрать
+func (q *MemoryCallbackQueue) Close() (err error) {
+	q.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.Unlock()
+	return
+}
+
+/*
+	Очередь с журналом на диске
+*/
+
+// FileCallbackQueue - очередь событий с журналом (WAL) на диске
+// Не подтвержденные события после перезапуска снова попадают в очередь
+type FileCallbackQueue struct {
+	path    string
+	f       *os.File
+	mem     *MemoryCallbackQueue
+	pending map[int64]CallbackQueueItem
+	lastID  int64
+	acked   int
+	closed  bool
+	sync.Mutex
+}
+
+// Запись журнала: push - событие, ack - событие обработано, seq - только последний номер
+type walRecord struct {
+	Op    string       `json:"op"`
+	ID    int64        `json:"id"`
+	Event *CallBackObj `json:"event,omitempty"`
+}
+
+// OpenFileCallbackQueue - открываем очередь, восстанавливаем не обработанные события из журнала
+func OpenFileCallbackQueue(path string) (q *FileCallbackQueue, err error) {
+	q = &FileCallbackQueue{
+		path:    path,
+		mem:     NewMemoryCallbackQueue(),
+		pending: make(map[int64]CallbackQueueItem),
+	}
+
+	var order []int64
+	f, err := os.Open(path)
+	if err == nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for sc.Scan() {
+			var rec walRecord
+			// Последняя запись могла быть недописана при падении
+			if json.Unmarshal(sc.Bytes(), &rec) != nil {
+				continue
+			}
+
+			switch rec.Op {
+			case "push":
+				if rec.Event == nil {
+					continue
+				}
+				q.pending[rec.ID] = CallbackQueueItem{ID: rec.ID, Event: *rec.Event}
+				order = append(order, rec.ID)
+			case "ack":
+				delete(q.pending, rec.ID)
+			}
+
+			// seq не подтверждает событие, только сдвигает счетчик
+
+			if rec.ID > q.lastID {
+				q.lastID = rec.ID
+			}
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			log.Println("[error]", err)
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		log.Println("[error]", err)
+		return
+	}
+
+	for _, id := range order {
+		if item, ok := q.pending[id]; ok {
+			q.mem.pushItem(item)
+		}
+	}
+
+	// Переписываем журнал только с не обработанными событиями
+	err = q.compact()
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// Push - пишем событие в журнал и добавляем в очередь
+func (q *FileCallbackQueue) Push(cbo CallBackObj) (err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		err = ErrQueueClosed
+		return
+	}
+
+	item := CallbackQueueItem{ID: q.lastID + 1, Event: cbo}
+	err = q.write(walRecord{Op: "push", ID: item.ID, Event: &cbo})
+	if err != nil {
+		return
+	}
+
+	q.lastID = item.ID
+	q.pending[item.ID] = item
+	err = q.mem.pushItem(item)
+	return
+}
+
+// Pop - забираем событие из очереди
+func (q *FileCallbackQueue) Pop() (item CallbackQueueItem, ok bool) {
+	return q.mem.Pop()
+}
+
+// Ack - отмечаем в журнале что событие обработано
+func (q *FileCallbackQueue) Ack(id int64) (err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if _, ok := q.pending[id]; !ok {
+		return
+	}
+
+	if q.f == nil {
+		err = ErrQueueClosed
+		return
+	}
+
+	err = q.write(walRecord{Op: "ack", ID: id})
+	if err != nil {
+		return
+	}
+
+	delete(q.pending, id)
+	q.acked++
+
+	// Журнал разросся - переписываем
+	if len(q.pending) == 0 || q.acked > 10000 {
+		err = q.compact()
+	}
+
+	if q.closed && len(q.pending) == 0 {
+		q.closeFile()
+	}
+
+	return
+}
+
+// Len - кол-во не обработанных событий
+func (q *FileCallbackQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.pending)
+}
+
+// Close - закрываем очередь
+// Журнал закрывается когда подтверждены все выданные события, остальные останутся в нем до перезапуска
+func (q *FileCallbackQueue) Close() (err error) {
+	q.mem.Close()
+
+	q.Lock()
+	defer q.Unlock()
+
+	q.closed = true
+	if len(q.pending) == 0 {
+		err = q.closeFile()
+	}
+	return
+}
+
+// Закрываем файл журнала
+func (q *FileCallbackQueue) closeFile() (err error) {
+	if q.f == nil {
+		return
+	}
+
+	err = q.f.Close()
+	if err != nil {
+		log.Println("[error]", err)
+	}
+	q.f = nil
+	return
+}
+
+// Пишем запись в журнал
+func (q *FileCallbackQueue) write(rec walRecord) (err error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	_, err = q.f.Write(append(b, '\n'))
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	err = q.f.Sync()
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	return
+}
+
+// Переписываем журнал оставив только не обработанные события
+func (q *FileCallbackQueue) compact() (err error) {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	ids := make([]int64, 0, len(q.pending))
+	for id := range q.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	w := bufio.NewWriter(f)
+	for _, id := range ids {
+		item := q.pending[id]
+
+		var b []byte
+		b, err = json.Marshal(walRecord{Op: "push", ID: id, Event: &item.Event})
+		if err != nil {
+			log.Println("[error]", err)
+			f.Close()
+			return
+		}
+		w.Write(append(b, '\n'))
+	}
+
+	// Сохраняем последний номер, чтобы ID не повторялись
+	b, _ := json.Marshal(walRecord{Op: "seq", ID: q.lastID})
+	w.Write(append(b, '\n'))
+
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	err = os.Rename(tmp, q.path)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	if q.f != nil {
+		q.f.Close()
+	}
+	q.f, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	q.acked = 0
+	return
+}
+
+/*
+	Обработчики очереди
+*/
+
+// CallbackDeadLetter - куда складываем события, которые так и не удалось обработать
+type CallbackDeadLetter interface {
+	Put(item CallbackQueueItem, err error) error
+}
+
+// CallbackDeadLetterFunc - функция как CallbackDeadLetter
+type CallbackDeadLetterFunc func(item CallbackQueueItem, err error) error
+
+// Put - вызываем функцию
+func (f CallbackDeadLetterFunc) Put(item CallbackQueueItem, err error) error {
+	return f(item, err)
+}
+
+// CallbackWorkerPool - пул обработчиков очереди событий
+// События одного диалога (peer_id) обрабатываются строго по порядку одним обработчиком
+type CallbackWorkerPool struct {
+	Queue   CallbackQueue
+	Workers int
+	// MaxAttempts - сколько раз пытаемся обработать событие
+	MaxAttempts int
+	// RetryDelay - пауза перед первым повтором, дальше удваивается до MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// DeadLetter - куда отдаем событие после всех попыток, если nil то только логируем
+	DeadLetter CallbackDeadLetter
+	OnEvent    func(cbo *CallBackObj) error
+
+	chans []chan CallbackQueueItem
+	wg    sync.WaitGroup
+}
+
+// Start - запускаем обработчики
+func (p *CallbackWorkerPool) Start() {
+	if p.Workers <= 0 {
+		p.Workers = 1
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.RetryDelay <= 0 {
+		p.RetryDelay = time.Second
+	}
+	if p.MaxRetryDelay <= 0 {
+		p.MaxRetryDelay = time.Minute
+	}
+
+	p.chans = make([]chan CallbackQueueItem, p.Workers)
+	for i := range p.chans {
+		p.chans[i] = make(chan CallbackQueueItem, 100)
+		p.wg.Add(1)
+		go p.worker(p.chans[i])
+	}
+
+	p.wg.Add(1)
+	go p.dispatch()
+}
+
+// Stop - закрываем очередь и ждем пока обработчики закончат
+func (p *CallbackWorkerPool) Stop() {
+	p.Queue.Close()
+	p.wg.Wait()
+}
+
+// Раскидываем события по обработчикам
+func (p *CallbackWorkerPool) dispatch() {
+	defer p.wg.Done()
+
+	for {
+		item, ok := p.Queue.Pop()
+		if !ok {
+			break
+		}
+
+		key := CallbackPeerKey(&item.Event)
+		if key < 0 {
+			key = -key
+		}
+		p.chans[key%len(p.chans)] <- item
+	}
+
+	for _, ch := range p.chans {
+		close(ch)
+	}
+}
+
+// Обработчик
+func (p *CallbackWorkerPool) worker(ch chan CallbackQueueItem) {
+	defer p.wg.Done()
+
+	for item := range ch {
+		p.process(item)
+	}
+}
+
+// Обрабатываем событие с повторами
+func (p *CallbackWorkerPool) process(item CallbackQueueItem) {
+	var err error
+	delay := p.RetryDelay
+
+	for item.Attempt = 1; item.Attempt <= p.MaxAttempts; item.Attempt++ {
+		err = p.call(&item.Event)
+		if err == nil {
+			break
+		}
+
+		if item.Attempt == p.MaxAttempts || exited {
+			break
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+		if delay > p.MaxRetryDelay {
+			delay = p.MaxRetryDelay
+		}
+	}
+
+	if err != nil {
+		if exited {
+			// Не подтверждаем - событие останется в журнале до перезапуска
+			return
+		}
+
+		log.Println("[error]", item.Event.Type, item.ID, err)
+		if p.DeadLetter != nil {
+			// Не удалось сохранить - все равно подтверждаем, иначе событие не отпустит обработчик
+			if derr := p.DeadLetter.Put(item, err); derr != nil {
+				log.Println("[error]", "dead letter", item.ID, derr, string(item.Event.Object))
+			}
+		}
+	}
+
+	if aerr := p.Queue.Ack(item.ID); aerr != nil {
+		log.Println("[error]", aerr)
+	}
+}
+
+// Вызываем обработчик, паника считается ошибкой
+func (p *CallbackWorkerPool) call(cbo *CallBackObj) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("panic in callback handler")
+			log.Println("[error]", r)
+		}
+	}()
+
+	err = cbo.Parse()
+	if err != nil {
+		return
+	}
+
+	if p.OnEvent != nil {
+		err = p.OnEvent(cbo)
+	}
+	return
+}
+
+// CallbackPeerKey - ключ для упорядочивания событий: диалог или пользователь, иначе группа
+func CallbackPeerKey(cbo *CallBackObj) int {
+	if cbo.Parse() == nil {
+		switch cbo.Type {
+		case "message_new", "message_reply", "message_edit":
+			return cbo.Message.PeerID
+		case "message_allow", "message_deny":
+			return cbo.MessageAllow.UserID
+		case "message_event":
+			return cbo.MessageEvent.PeerID
+		}
+	}
+
+	return cbo.GroupID
+}
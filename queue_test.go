@@ -0,0 +1,96 @@
+package vkapi
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCallbackQueueReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+
+	q, err := OpenFileCallbackQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Push(CallBackObj{Type: "message_new", GroupID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	// Каждое открытие сжимает журнал, событие должно пережить оба
+	for i := 0; i < 2; i++ {
+		q, err = OpenFileCallbackQueue(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Len() != 1 {
+			t.Fatalf("reopen %d: %d pending, want 1", i+1, q.Len())
+		}
+		q.Close()
+	}
+
+	q, err = OpenFileCallbackQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Push(CallBackObj{Type: "message_new", GroupID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := q.Pop()
+	second, _ := q.Pop()
+	if first.ID != 1 || second.ID != 2 {
+		t.Fatalf("ids %d %d, want 1 2", first.ID, second.ID)
+	}
+
+	if err = q.Ack(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	q, err = OpenFileCallbackQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	item, _ := q.Pop()
+	if q.Len() != 1 || item.ID != 2 {
+		t.Fatalf("%d pending, id %d, want 1 pending with id 2", q.Len(), item.ID)
+	}
+}
+
+func TestCallbackWorkerPoolDeadLetterError(t *testing.T) {
+	q, err := OpenFileCallbackQueue(filepath.Join(t.TempDir(), "queue.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &CallbackWorkerPool{
+		Queue:       q,
+		MaxAttempts: 1,
+		OnEvent:     func(cbo *CallBackObj) error { return errors.New("handler failed") },
+		DeadLetter: CallbackDeadLetterFunc(func(item CallbackQueueItem, err error) error {
+			return errors.New("dead letter failed")
+		}),
+	}
+	p.Start()
+
+	for i := 0; i < 3; i++ {
+		if err = q.Push(CallBackObj{Type: "confirmation", GroupID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if q.Len() != 0 {
+		t.Fatalf("%d events still pending", q.Len())
+	}
+
+	p.Stop()
+}
@@ -0,0 +1,248 @@
+package vkapi
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CallbackServerConfig - желаемая настройка callback сервера группы
+type CallbackServerConfig struct {
+	GroupID   int
+	URL       string
+	Title     string
+	SecretKey string
+	// APIVersion - версия API событий, если пусто то APIVersion
+	APIVersion string
+	// Events - включенные типы событий, остальные выключаются
+	Events []string
+	// DeleteOthers - удалять остальные callback сервера группы
+	DeleteOthers bool
+}
+
+// CallbackPlanAction - действие для приведения настроек к желаемым
+type CallbackPlanAction struct {
+	GroupID  int
+	Action   string // add, edit, delete, settings
+	ServerID int
+	// Params - параметры запроса, для settings только изменившиеся
+	Params map[string]string
+}
+
+// CallbackPlan - список действий
+type CallbackPlan []CallbackPlanAction
+
+// String - План в читаемом виде
+func (a CallbackPlanAction) String() string {
+	keys := make([]string, 0, len(a.Params))
+	for k := range a.Params {
+		if k == "group_id" || k == "server_id" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	arr := make([]string, len(keys))
+	for i, k := range keys {
+		v := a.Params[k]
+		if k == "secret_key" {
+			v = "***"
+		}
+		arr[i] = k + "=" + v
+	}
+
+	return fmt.Sprintf("group %d: %s server %d %s", a.GroupID, a.Action, a.ServerID, strings.Join(arr, " "))
+}
+
+// String - План в читаемом виде
+func (p CallbackPlan) String() string {
+	arr := make([]string, len(p))
+	for i, a := range p {
+		arr[i] = a.String()
+	}
+	return strings.Join(arr, "\n")
+}
+
+// ReconcileCallbackServers - приводим callback сервера нескольких групп к желаемым настройкам
+// Токен должен иметь доступ ко всем группам. При dryRun только возвращаем план
+func (vk *API) ReconcileCallbackServers(cfgs []CallbackServerConfig, dryRun bool) (plan CallbackPlan, err error) {
+	for _, cfg := range cfgs {
+		var p CallbackPlan
+		p, err = vk.ReconcileCallbackServer(cfg, dryRun)
+		plan = append(plan, p...)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// ReconcileCallbackServer - приводим callback сервер группы к желаемым настройкам
+// Сервер ищется по названию, затем по URL. При dryRun только возвращаем план
+func (vk *API) ReconcileCallbackServer(cfg CallbackServerConfig, dryRun bool) (plan CallbackPlan, err error) {
+	strGroupID := strconv.Itoa(cfg.GroupID)
+
+	servers, err := vk.GroupsGetCallbackServers(map[string]string{"group_id": strGroupID})
+	if err != nil {
+		return
+	}
+
+	// Ищем нужный сервер
+	var server *GroupsGetCallbackServersAnsItem
+	for i, s := range servers.Items {
+		if s.Title == cfg.Title {
+			server = &servers.Items[i]
+			break
+		}
+	}
+	if server == nil {
+		for i, s := range servers.Items {
+			if s.URL == cfg.URL {
+				server = &servers.Items[i]
+				break
+			}
+		}
+	}
+
+	if cfg.DeleteOthers {
+		for _, s := range servers.Items {
+			if server != nil && s.ID == server.ID {
+				continue
+			}
+			plan = append(plan, CallbackPlanAction{
+				GroupID:  cfg.GroupID,
+				Action:   "delete",
+				ServerID: s.ID,
+				Params:   map[string]string{"group_id": strGroupID, "server_id": strconv.Itoa(s.ID)},
+			})
+		}
+	}
+
+	// Текущие настройки событий
+	var current GroupsGetCallbackSettingsAns
+	if server == nil {
+		plan = append(plan, CallbackPlanAction{
+			GroupID: cfg.GroupID,
+			Action:  "add",
+			Params: map[string]string{
+				"group_id":   strGroupID,
+				"url":        cfg.URL,
+				"title":      cfg.Title,
+				"secret_key": cfg.SecretKey,
+			},
+		})
+	} else {
+		if server.URL != cfg.URL || server.Title != cfg.Title || server.SecretKey != cfg.SecretKey {
+			plan = append(plan, CallbackPlanAction{
+				GroupID:  cfg.GroupID,
+				Action:   "edit",
+				ServerID: server.ID,
+				Params: map[string]string{
+					"group_id":   strGroupID,
+					"server_id":  strconv.Itoa(server.ID),
+					"url":        cfg.URL,
+					"title":      cfg.Title,
+					"secret_key": cfg.SecretKey,
+				},
+			})
+		}
+
+		current, err = vk.GroupsGetCallbackSettings(map[string]string{
+			"group_id":  strGroupID,
+			"server_id": strconv.Itoa(server.ID),
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// Настройки событий
+	settings := callbackSettingsDiff(cfg, current)
+	if len(settings) > 0 {
+		settings["group_id"] = strGroupID
+		a := CallbackPlanAction{
+			GroupID: cfg.GroupID,
+			Action:  "settings",
+			Params:  settings,
+		}
+		if server != nil {
+			a.ServerID = server.ID
+			a.Params["server_id"] = strconv.Itoa(server.ID)
+		}
+		plan = append(plan, a)
+	}
+
+	if dryRun {
+		return
+	}
+
+	err = vk.ApplyCallbackPlan(plan)
+	return
+}
+
+// ApplyCallbackPlan - выполняем план
+// Для добавленного сервера id подставляется в следующие действия группы, сам план не меняется
+func (vk *API) ApplyCallbackPlan(plan CallbackPlan) (err error) {
+	newIDs := make(map[int]int)
+
+	for _, a := range plan {
+		if a.ServerID == 0 && a.Action != "add" {
+			a.ServerID = newIDs[a.GroupID]
+			a.Params = copyParams(a.Params)
+			a.Params["server_id"] = strconv.Itoa(a.ServerID)
+		}
+
+		switch a.Action {
+		case "add":
+			var ans GroupsAddCallbackServerAns
+			ans, err = vk.GroupsAddCallbackServer(a.Params)
+			newIDs[a.GroupID] = ans.ServerID
+		case "edit":
+			_, err = vk.GroupsEditCallbackServer(a.Params)
+		case "delete":
+			_, err = vk.GroupsDeleteCallbackServer(a.Params)
+		case "settings":
+			_, err = vk.GroupsSetCallbackSettings(a.Params)
+		}
+
+		if err != nil {
+			log.Println("[error]", a.String(), err)
+			return
+		}
+	}
+
+	return
+}
+
+// Считаем какие настройки событий надо поменять
+func callbackSettingsDiff(cfg CallbackServerConfig, current GroupsGetCallbackSettingsAns) (params map[string]string) {
+	params = make(map[string]string)
+
+	v := cfg.APIVersion
+	if v == "" {
+		v = APIVersion
+	}
+	if current.APIVersion != v {
+		params["api_version"] = v
+	}
+
+	want := make(map[string]bool, len(cfg.Events))
+	for _, e := range cfg.Events {
+		want[e] = true
+		if current.Events[e] != 1 {
+			params[e] = "1"
+		}
+	}
+
+	for e, on := range current.Events {
+		if on == 1 && !want[e] {
+			params[e] = "0"
+		}
+	}
+
+	return
+}
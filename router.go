@@ -0,0 +1,158 @@
+package vkapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	// MessageEventSnackbarMaxLen - максимальная длина текста snackbar
+	MessageEventSnackbarMaxLen = 90
+)
+
+// CallbackRouter - маршрутизация событий callback по типу
+// Метод Handle можно использовать как OnEvent обработчика или очереди
+type CallbackRouter struct {
+	// API - от имени кого отвечаем на нажатия callback кнопок
+	API *API
+	// Default - обработчик событий без своего обработчика
+	Default func(cbo *CallBackObj) error
+
+	h        map[string]func(cbo *CallBackObj) error
+	commands map[string]func(cbo *CallBackObj) error
+}
+
+// NewCallbackRouter - создаем роутер
+func NewCallbackRouter(vk *API) *CallbackRouter {
+	return &CallbackRouter{
+		API:      vk,
+		h:        make(map[string]func(cbo *CallBackObj) error),
+		commands: make(map[string]func(cbo *CallBackObj) error),
+	}
+}
+
+// On - задаем обработчик для типа события
+func (r *CallbackRouter) On(eventType string, f func(cbo *CallBackObj) error) {
+	if r.h == nil {
+		r.h = make(map[string]func(cbo *CallBackObj) error)
+	}
+	r.h[eventType] = f
+}
+
+// OnMessageEvent - обработчик нажатия callback кнопки
+// Что вернет обработчик (snackbar, ссылка, приложение) отправляется пользователю, nil - просто убираем загрузку с кнопки
+func (r *CallbackRouter) OnMessageEvent(f func(e CallbackMessageEvent) (*MessageEventData, error)) {
+	r.On("message_event", func(cbo *CallBackObj) (err error) {
+		data, err := f(cbo.MessageEvent)
+		if err != nil {
+			return
+		}
+
+		if r.API == nil {
+			return
+		}
+
+		_, err = r.API.AnswerMessageEvent(cbo.MessageEvent, data)
+		return
+	})
+}
+
+// OnCommand - обработчик команды из payload ({"cmd":"..."}) для message_new и message_event
+// Имеет приоритет над обработчиком типа. На нажатие callback кнопки надо ответить самому через AnswerMessageEvent
+func (r *CallbackRouter) OnCommand(cmd string, f func(cbo *CallBackObj) error) {
+	if r.commands == nil {
+		r.commands = make(map[string]func(cbo *CallBackObj) error)
+	}
+	r.commands[cmd] = f
+}
+
+// Handle - передаем событие нужному обработчику
+func (r *CallbackRouter) Handle(cbo *CallBackObj) (err error) {
+	err = cbo.Parse()
+	if err != nil {
+		return
+	}
+
+	if cmd := cbo.PayloadCommand(); cmd != "" {
+		if f, ok := r.commands[cmd]; ok {
+			return f(cbo)
+		}
+	}
+
+	f, ok := r.h[cbo.Type]
+	if !ok {
+		f = r.Default
+	}
+	if f == nil {
+		return
+	}
+
+	return f(cbo)
+}
+
+// SnackbarEventData - показать всплывающее сообщение
+func SnackbarEventData(text string) *MessageEventData {
+	return &MessageEventData{Type: "show_snackbar", Text: text}
+}
+
+// OpenLinkEventData - открыть ссылку
+func OpenLinkEventData(link string) *MessageEventData {
+	return &MessageEventData{Type: "open_link", Link: link}
+}
+
+// OpenAppEventData - открыть VK Mini App
+func OpenAppEventData(appID, ownerID int, hash string) *MessageEventData {
+	return &MessageEventData{Type: "open_app", AppID: appID, OwnerID: ownerID, Hash: hash}
+}
+
+// Validate - проверяем данные ответа
+func (d *MessageEventData) Validate() (err error) {
+	switch d.Type {
+	case "show_snackbar":
+		if d.Text == "" || utf8.RuneCountInString(d.Text) > MessageEventSnackbarMaxLen {
+			err = errors.New("snackbar text must be 1-" + strconv.Itoa(MessageEventSnackbarMaxLen) + " characters")
+		}
+	case "open_link":
+		if d.Link == "" {
+			err = errors.New("open_link without link")
+		}
+	case "open_app":
+		if d.AppID == 0 {
+			err = errors.New("open_app without app_id")
+		}
+	default:
+		err = errors.New("unknown message event action: " + d.Type)
+	}
+
+	return
+}
+
+// AnswerMessageEvent - отвечаем на нажатие callback кнопки, data может быть nil
+func (vk *API) AnswerMessageEvent(e CallbackMessageEvent, data *MessageEventData) (ans int, err error) {
+	params := map[string]string{
+		"event_id": e.EventID,
+		"user_id":  strconv.Itoa(e.UserID),
+		"peer_id":  strconv.Itoa(e.PeerID),
+	}
+
+	if data != nil {
+		err = data.Validate()
+		if err != nil {
+			log.Println("[error]", err)
+			return
+		}
+
+		var b []byte
+		b, err = json.Marshal(data)
+		if err != nil {
+			log.Println("[error]", err)
+			return
+		}
+		params["event_data"] = string(b)
+	}
+
+	return vk.MessagesSendMessageEventAnswer(params)
+}
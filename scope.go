@@ -0,0 +1,216 @@
+package vkapi
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Scope - битовая маска прав пользовательского токена
+type Scope int
+
+// Права пользовательского токена
+const (
+	ScopeNotify        Scope = 1
+	ScopeFriends       Scope = 2
+	ScopePhotos        Scope = 4
+	ScopeAudio         Scope = 8
+	ScopeVideo         Scope = 16
+	ScopeStories       Scope = 64
+	ScopePages         Scope = 128
+	ScopeMenu          Scope = 256
+	ScopeStatus        Scope = 1024
+	ScopeNotes         Scope = 2048
+	ScopeMessages      Scope = 4096
+	ScopeWall          Scope = 8192
+	ScopeAds           Scope = 32768
+	ScopeOffline       Scope = 65536
+	ScopeDocs          Scope = 131072
+	ScopeGroups        Scope = 262144
+	ScopeNotifications Scope = 524288
+	ScopeStats         Scope = 1048576
+	ScopeEmail         Scope = 4194304
+	ScopeMarket        Scope = 134217728
+	ScopePhoneNumber   Scope = 268435456
+)
+
+// GroupScope - битовая маска прав токена сообщества
+type GroupScope int
+
+// Права токена сообщества
+const (
+	GroupScopeStories   GroupScope = 1
+	GroupScopePhotos    GroupScope = 4
+	GroupScopeAppWidget GroupScope = 64
+	GroupScopeMessages  GroupScope = 4096
+	GroupScopeDocs      GroupScope = 131072
+	GroupScopeManage    GroupScope = 262144
+)
+
+type scopeName struct {
+	mask int
+	name string
+}
+
+var (
+	scopeNames = []scopeName{
+		{1, "notify"}, {2, "friends"}, {4, "photos"}, {8, "audio"}, {16, "video"},
+		{64, "stories"}, {128, "pages"}, {256, "menu"}, {1024, "status"}, {2048, "notes"},
+		{4096, "messages"}, {8192, "wall"}, {32768, "ads"}, {65536, "offline"}, {131072, "docs"},
+		{262144, "groups"}, {524288, "notifications"}, {1048576, "stats"}, {4194304, "email"},
+		{134217728, "market"}, {268435456, "phone_number"},
+	}
+	groupScopeNames = []scopeName{
+		{1, "stories"}, {4, "photos"}, {64, "app_widget"}, {4096, "messages"}, {131072, "docs"}, {262144, "manage"},
+	}
+
+	// Какое право нужно для методов раздела
+	methodScopes = map[string]Scope{
+		"friends": ScopeFriends, "photos": ScopePhotos, "audio": ScopeAudio, "video": ScopeVideo,
+		"stories": ScopeStories, "pages": ScopePages, "status": ScopeStatus, "notes": ScopeNotes,
+		"messages": ScopeMessages, "wall": ScopeWall, "ads": ScopeAds, "docs": ScopeDocs,
+		"groups": ScopeGroups, "notifications": ScopeNotifications, "stats": ScopeStats, "market": ScopeMarket,
+	}
+	methodGroupScopes = map[string]GroupScope{
+		"stories": GroupScopeStories, "photos": GroupScopePhotos, "appWidgets": GroupScopeAppWidget,
+		"messages": GroupScopeMessages, "docs": GroupScopeDocs, "groups": GroupScopeManage,
+	}
+	// Методы, которым не нужно право своего раздела
+	methodScopeFree = map[string]bool{
+		"wall.get": true, "wall.getById": true, "wall.getComments": true, "wall.search": true,
+		"groups.getById": true, "groups.getMembers": true, "groups.isMember": true, "groups.search": true,
+		"photos.get": true, "photos.getById": true, "photos.getAlbums": true,
+		"video.get": true, "friends.get": true, "market.get": true, "market.getById": true,
+		"groups.getTokenPermissions": true,
+	}
+)
+
+// ParseScope - права из строки вида "wall,offline" или числа
+func ParseScope(str string) (s Scope, err error) {
+	m, err := parseScopeMask(str, scopeNames)
+	return Scope(m), err
+}
+
+// Has - есть ли все права из s2
+func (s Scope) Has(s2 Scope) bool {
+	return s&s2 == s2
+}
+
+// Names - названия прав
+func (s Scope) Names() []string {
+	return scopeMaskNames(int(s), scopeNames)
+}
+
+// String - права строкой для параметра scope
+func (s Scope) String() string {
+	return strings.Join(s.Names(), ",")
+}
+
+// ParseGroupScope - права сообщества из строки вида "messages,manage" или числа
+func ParseGroupScope(str string) (s GroupScope, err error) {
+	m, err := parseScopeMask(str, groupScopeNames)
+	return GroupScope(m), err
+}
+
+// Has - есть ли все права из s2
+func (s GroupScope) Has(s2 GroupScope) bool {
+	return s&s2 == s2
+}
+
+// Names - названия прав
+func (s GroupScope) Names() []string {
+	return scopeMaskNames(int(s), groupScopeNames)
+}
+
+// String - права строкой для параметра scope
+func (s GroupScope) String() string {
+	return strings.Join(s.Names(), ",")
+}
+
+// RequiredScope - права пользовательского токена, нужные для методов
+func RequiredScope(methods ...string) (s Scope) {
+	for _, m := range methods {
+		if !methodScopeFree[m] {
+			s |= methodScopes[methodSection(m)]
+		}
+	}
+	return
+}
+
+// RequiredGroupScope - права токена сообщества, нужные для методов
+func RequiredGroupScope(methods ...string) (s GroupScope) {
+	for _, m := range methods {
+		if !methodScopeFree[m] {
+			s |= methodGroupScopes[methodSection(m)]
+		}
+	}
+	return
+}
+
+// MissingScope - каких прав не хватает пользовательскому токену для методов
+func (vk *API) MissingScope(methods ...string) (missing Scope, err error) {
+	mask, err := vk.AccountGetAppPermissions(map[string]string{})
+	if err != nil {
+		return
+	}
+
+	missing = RequiredScope(methods...) &^ Scope(mask)
+	return
+}
+
+// MissingGroupScope - каких прав не хватает токену сообщества для методов
+func (vk *API) MissingGroupScope(methods ...string) (missing GroupScope, err error) {
+	ans, err := vk.GroupsGetTokenPermissions()
+	if err != nil {
+		return
+	}
+
+	missing = RequiredGroupScope(methods...) &^ GroupScope(ans.Mask)
+	return
+}
+
+func methodSection(method string) string {
+	if i := strings.Index(method, "."); i != -1 {
+		return method[:i]
+	}
+	return method
+}
+
+func parseScopeMask(str string, names []scopeName) (mask int, err error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return
+	}
+
+	if n, cerr := strconv.Atoi(str); cerr == nil {
+		mask = n
+		return
+	}
+
+	for _, v := range strings.Split(str, ",") {
+		v = strings.TrimSpace(v)
+		found := false
+		for _, sn := range names {
+			if sn.name == v {
+				mask |= sn.mask
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = errors.New("unknown scope: " + v)
+			return
+		}
+	}
+
+	return
+}
+
+func scopeMaskNames(mask int, names []scopeName) (arr []string) {
+	for _, sn := range names {
+		if mask&sn.mask != 0 {
+			arr = append(arr, sn.name)
+		}
+	}
+	return
+}
@@ -0,0 +1,220 @@
+package vkapi
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CallbackSecrets - активные секретные ключи callback по группам
+// Во время смены ключа у группы может быть несколько активных ключей
+// События групп без ключей отклоняются, если не задан AllowUnconfigured
+type CallbackSecrets struct {
+	// AllowUnconfigured - пропускать события групп, для которых ключ не задан
+	AllowUnconfigured bool
+
+	h map[int][]callbackSecret
+	sync.RWMutex
+}
+
+type callbackSecret struct {
+	secret  string
+	expires time.Time // нулевое время - бессрочно
+}
+
+// NewCallbackSecrets - создаем хранилище ключей, secrets - начальные ключи по группам
+func NewCallbackSecrets(secrets map[int]string) *CallbackSecrets {
+	s := &CallbackSecrets{}
+	for groupID, secret := range secrets {
+		s.Set(groupID, secret)
+	}
+	return s
+}
+
+// Set - задаем единственный ключ группы
+func (s *CallbackSecrets) Set(groupID int, secret string) {
+	s.Lock()
+	s.init()
+	s.h[groupID] = []callbackSecret{{secret: secret}}
+	s.Unlock()
+}
+
+// Add - добавляем ключ группе, остальные ключи остаются активными
+func (s *CallbackSecrets) Add(groupID int, secret string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.init()
+	for i, cs := range s.h[groupID] {
+		if cs.secret == secret {
+			s.h[groupID][i].expires = time.Time{}
+			return
+		}
+	}
+	s.h[groupID] = append(s.h[groupID], callbackSecret{secret: secret})
+}
+
+// Retire - ключ перестанет приниматься через grace
+func (s *CallbackSecrets) Retire(groupID int, secret string, grace time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.init()
+	now := time.Now()
+	exp := now.Add(grace)
+	arr := s.h[groupID][:0]
+	for _, cs := range s.h[groupID] {
+		// Заодно убираем протухшие
+		if !cs.expires.IsZero() && now.After(cs.expires) {
+			continue
+		}
+
+		if cs.secret == secret {
+			if grace <= 0 {
+				continue
+			}
+			cs.expires = exp
+		}
+		arr = append(arr, cs)
+	}
+	s.h[groupID] = arr
+}
+
+// Карта создается при первой записи, чтобы работало нулевое значение
+func (s *CallbackSecrets) init() {
+	if s.h == nil {
+		s.h = make(map[int][]callbackSecret)
+	}
+}
+
+// Active - список действующих ключей группы
+func (s *CallbackSecrets) Active(groupID int) (secrets []string) {
+	now := time.Now()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, cs := range s.h[groupID] {
+		if cs.expires.IsZero() || now.Before(cs.expires) {
+			secrets = append(secrets, cs.secret)
+		}
+	}
+	return
+}
+
+// Check - проверяем ключ из запроса. Если у группы ключей нет, то отклоняем (или пропускаем при AllowUnconfigured)
+// Сравнение за постоянное время, перебираются все ключи
+func (s *CallbackSecrets) Check(groupID int, secret string) (ok bool) {
+	s.RLock()
+	_, configured := s.h[groupID]
+	s.RUnlock()
+
+	if !configured {
+		return s.AllowUnconfigured
+	}
+
+	var match int
+	for _, as := range s.Active(groupID) {
+		match |= subtle.ConstantTimeCompare([]byte(as), []byte(secret))
+	}
+
+	ok = match == 1
+	return
+}
+
+// GenerateCallbackSecret - генерируем новый секретный ключ
+func GenerateCallbackSecret() (secret string, err error) {
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	secret = hex.EncodeToString(b)
+	return
+}
+
+// RotateCallbackSecret - меняем секретный ключ callback сервера
+// Новый ключ принимается сразу, старые - еще grace. Если newSecret пустой то генерируем
+func (vk *API) RotateCallbackSecret(secrets *CallbackSecrets, groupID, serverID int, newSecret string, grace time.Duration) (secret string, err error) {
+	strGroupID := strconv.Itoa(groupID)
+	strServerID := strconv.Itoa(serverID)
+
+	servers, err := vk.GroupsGetCallbackServers(map[string]string{
+		"group_id":   strGroupID,
+		"server_ids": strServerID,
+	})
+	if err != nil {
+		return
+	}
+
+	var server *GroupsGetCallbackServersAnsItem
+	for i, s := range servers.Items {
+		if s.ID == serverID {
+			server = &servers.Items[i]
+			break
+		}
+	}
+	if server == nil {
+		err = errors.New("callback server not found")
+		log.Println("[error]", err, groupID, serverID)
+		return
+	}
+
+	secret = newSecret
+	if secret == "" {
+		secret, err = GenerateCallbackSecret()
+		if err != nil {
+			return
+		}
+	}
+
+	old := secrets.Active(groupID)
+
+	// Ключ мог остаться активным после прошлой попытки - тогда при ошибке его не трогаем
+	added := true
+	for _, o := range old {
+		if o == secret {
+			added = false
+			break
+		}
+	}
+
+	// Новый ключ должен приниматься еще до того как ВК начнет им подписывать
+	if added {
+		secrets.Add(groupID, secret)
+	}
+
+	_, err = vk.GroupsEditCallbackServer(map[string]string{
+		"group_id":   strGroupID,
+		"server_id":  strServerID,
+		"url":        server.URL,
+		"title":      server.Title,
+		"secret_key": secret,
+	})
+	if err != nil {
+		if added {
+			secrets.Retire(groupID, secret, 0)
+		}
+		return
+	}
+
+	// Ключ мог быть в grace после прошлой смены - делаем его бессрочным
+	if !added {
+		secrets.Add(groupID, secret)
+	}
+
+	for _, o := range old {
+		if o != secret {
+			secrets.Retire(groupID, o, grace)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,64 @@
+package vkapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallbackSecretsCheck(t *testing.T) {
+	var s CallbackSecrets
+	if s.Check(1, "") {
+		t.Fatal("group without secret accepted")
+	}
+
+	s.Set(1, "old")
+	s.Add(1, "new")
+	if !s.Check(1, "old") || !s.Check(1, "new") || s.Check(1, "bad") {
+		t.Fatal(s.Active(1))
+	}
+
+	s.Retire(1, "old", 0)
+	if s.Check(1, "old") || !s.Check(1, "new") {
+		t.Fatal(s.Active(1))
+	}
+
+	s.Retire(1, "new", time.Hour)
+	if !s.Check(1, "new") {
+		t.Fatal("secret rejected during grace")
+	}
+
+	s.AllowUnconfigured = true
+	if !s.Check(2, "") || s.Check(1, "") {
+		t.Fatal("AllowUnconfigured")
+	}
+}
+
+func TestZeroValueRouterAndBot(t *testing.T) {
+	var r CallbackRouter
+	var got string
+	r.On("message_new", func(cbo *CallBackObj) error {
+		got = cbo.Message.Text
+		return nil
+	})
+	if err := r.Handle(&CallBackObj{Type: "message_new", Object: []byte(`{"text":"hi","peer_id":1}`)}); err != nil || got != "hi" {
+		t.Fatal(got, err)
+	}
+
+	b := &Bot{}
+	b.Scene("start").Default = func(ctx *BotContext) error {
+		return ctx.Set("k", "v")
+	}
+	b.Default = func(ctx *BotContext) error {
+		return ctx.Enter("start")
+	}
+	bt := NewBotTester(b)
+	if err := bt.SendMessage(1, "a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.SendMessage(1, "b", ""); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := b.States.Get(1); st.Scene != "start" || st.Data["k"] != "v" {
+		t.Fatal(st)
+	}
+}
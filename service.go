@@ -0,0 +1,151 @@
+package vkapi
+
+import (
+	"errors"
+	"sync"
+)
+
+// ServiceTokenMethods - методы, которые можно вызывать с сервисным токеном приложения
+var ServiceTokenMethods = map[string]bool{
+	"users.get":               true,
+	"users.getFollowers":      true,
+	"users.getSubscriptions":  true,
+	"groups.getById":          true,
+	"groups.getMembers":       true,
+	"groups.isMember":         true,
+	"wall.get":                true,
+	"wall.getById":            true,
+	"wall.getComments":        true,
+	"wall.getComment":         true,
+	"wall.getReposts":         true,
+	"photos.get":              true,
+	"photos.getAlbums":        true,
+	"photos.getById":          true,
+	"board.getTopics":         true,
+	"board.getComments":       true,
+	"likes.getList":           true,
+	"friends.get":             true,
+	"utils.resolveScreenName": true,
+	"utils.checkLink":         true,
+	"utils.getShortLink":      true,
+	"utils.getServerTime":     true,
+	"database.getCountries":   true,
+	"database.getCities":      true,
+	"secure.checkToken":       true,
+	"apps.get":                true,
+}
+
+// NewServiceAPI - API с сервисным токеном, методы не из ServiceTokenMethods вернут ошибку
+func NewServiceAPI(serviceToken string) *API {
+	return &API{ServiceToken: serviceToken}
+}
+
+// Выбираем токен для запроса: основной, из пула, сервисный
+func (vk *API) token(method string) (token string, fromPool bool, err error) {
+	if vk.AccessToken != "" {
+		token = vk.AccessToken
+		return
+	}
+
+	if vk.Pool != nil {
+		token = vk.Pool.Get()
+		if token != "" {
+			fromPool = true
+			return
+		}
+	}
+
+	if vk.ServiceToken != "" {
+		if ServiceTokenMethods[method] {
+			token = vk.ServiceToken
+			return
+		}
+		err = errors.New("method " + method + " is not available with service token")
+		return
+	}
+
+	err = errors.New("no access token")
+	return
+}
+
+/*
+	Пул токенов
+*/
+
+// TokenPool - пул пользовательских токенов, выдаются по кругу
+type TokenPool struct {
+	tokens  []string
+	invalid map[string]bool
+	next    int
+	sync.Mutex
+}
+
+// NewTokenPool - создаем пул токенов
+func NewTokenPool(tokens ...string) *TokenPool {
+	p := &TokenPool{invalid: make(map[string]bool)}
+	p.Add(tokens...)
+	return p
+}
+
+// Add - добавляем токены, повторно добавленный токен снова считается рабочим
+func (p *TokenPool) Add(tokens ...string) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, t := range tokens {
+		if t == "" {
+			continue
+		}
+		if p.invalid[t] {
+			delete(p.invalid, t)
+			continue
+		}
+
+		exists := false
+		for _, v := range p.tokens {
+			if v == t {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			p.tokens = append(p.tokens, t)
+		}
+	}
+}
+
+// Get - следующий рабочий токен, пустая строка если таких нет
+func (p *TokenPool) Get() (token string) {
+	p.Lock()
+	defer p.Unlock()
+
+	for range p.tokens {
+		t := p.tokens[p.next%len(p.tokens)]
+		p.next++
+		if !p.invalid[t] {
+			return t
+		}
+	}
+
+	return
+}
+
+// Invalidate - помечаем токен нерабочим
+func (p *TokenPool) Invalidate(token string) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, v := range p.tokens {
+		if v == token {
+			p.invalid[token] = true
+			return
+		}
+	}
+}
+
+// Valid - кол-во рабочих токенов
+func (p *TokenPool) Valid() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.tokens) - len(p.invalid)
+}
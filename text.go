@@ -0,0 +1,214 @@
+package vkapi
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MessageMaxLen - максимальная длина текста сообщения
+	MessageMaxLen = 4096
+	// MentionAll - упомянуть всех участников беседы
+	MentionAll = "@all"
+	// MentionOnline - упомянуть участников беседы онлайн
+	MentionOnline = "@online"
+)
+
+var (
+	textMentionReg   *regexp.Regexp
+	textShortReg     *regexp.Regexp
+	textNamedLinkReg *regexp.Regexp
+	textLinkReg      *regexp.Regexp
+	textHashtagReg   *regexp.Regexp
+	textEscapeReg    *regexp.Regexp
+	mentionNameRepl  *strings.Replacer
+)
+
+func init() {
+	textMentionReg = regexp.MustCompile(`\[((id|club|public|event|group)([0-9]+)|[a-zA-Z0-9_.]+)\|([^\]]+)\]`)
+	textShortReg = regexp.MustCompile(`[@*](id|club|public|event)([0-9]+)(?:\s*\(([^)]*)\))?`)
+	textNamedLinkReg = regexp.MustCompile(`\[(https?://[^|\]\s]+)\|([^\]]+)\]`)
+	textLinkReg = regexp.MustCompile(`(?i)\b(?:https?://|(?:m\.)?vk\.com/|vk\.cc/)[^\s<>"'|\[\]]+`)
+	textHashtagReg = regexp.MustCompile(`#[\p{L}\p{N}_]+(?:@[a-zA-Z0-9_.]+)?`)
+	textEscapeReg = regexp.MustCompile(`[\[@*#]`)
+	mentionNameRepl = strings.NewReplacer("[", "(", "]", ")", "|", "/")
+}
+
+// TextEntity - найденный в тексте объект
+type TextEntity struct {
+	// Type - mention, link или hashtag
+	Type string
+	// Offset, Length - позиция в тексте в символах (рунах)
+	Offset int
+	Length int
+	Text   string
+	// OwnerID - для упоминаний: id человека или -id сообщества, 0 если указан screen_name
+	OwnerID    int
+	ScreenName string
+	Name       string
+	URL        string
+}
+
+/*
+	Построение текста
+*/
+
+// Mention - упоминание человека (id > 0) или сообщества (id < 0) вида [id1|Имя]
+func Mention(id int, name string) string {
+	name = mentionNameRepl.Replace(name)
+	if id < 0 {
+		return "[club" + strconv.Itoa(-id) + "|" + name + "]"
+	}
+	return "[id" + strconv.Itoa(id) + "|" + name + "]"
+}
+
+// MentionScreenName - упоминание по короткому имени вида [durov|Павел]
+func MentionScreenName(screenName, name string) string {
+	return "[" + screenName + "|" + mentionNameRepl.Replace(name) + "]"
+}
+
+// Link - ссылка с текстом, ВК так оформляет только ссылки на vk.com
+func Link(url, text string) string {
+	return "[" + url + "|" + mentionNameRepl.Replace(text) + "]"
+}
+
+// EscapeText - экранируем пользовательский текст, чтобы ВК не превратил его в упоминания, ссылки и хэштеги
+// После спецсимволов и точек в ссылках вставляется невидимый символ (word joiner)
+func EscapeText(text string) string {
+	text = textEscapeReg.ReplaceAllStringFunc(text, func(s string) string {
+		return s + "\u2060"
+	})
+
+	return textLinkReg.ReplaceAllStringFunc(text, func(s string) string {
+		return strings.Replace(s, ".", ".\u2060", -1)
+	})
+}
+
+// SplitText - разбиваем длинный текст на части не длиннее max символов
+// Режем по абзацам, потом по строкам, потом по словам, и только если иначе нельзя - посреди слова
+func SplitText(text string, max int) (parts []string) {
+	if max <= 0 {
+		max = MessageMaxLen
+	}
+
+	for utf8.RuneCountInString(text) > max {
+		// Байтовые позиции max-го символа и середины окна
+		limit := len(text)
+		half := 0
+		n := 0
+		for i := range text {
+			if n == max/2 {
+				half = i
+			}
+			if n == max {
+				limit = i
+				break
+			}
+			n++
+		}
+
+		// Разделитель ищем во второй половине окна, иначе части выйдут слишком короткими
+		cut := -1
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			if i := strings.LastIndex(text[:limit], sep); i > 0 && i >= half {
+				cut = i
+				break
+			}
+		}
+
+		var part string
+		if cut == -1 {
+			part, text = text[:limit], text[limit:]
+		} else {
+			part, text = text[:cut], text[cut:]
+		}
+
+		part = strings.TrimRight(part, " \n")
+		if part != "" {
+			parts = append(parts, part)
+		}
+		text = strings.TrimLeft(text, " \n")
+	}
+
+	if text != "" {
+		parts = append(parts, text)
+	}
+
+	return
+}
+
+/*
+	Разбор текста
+*/
+
+// ParseText - находим в тексте сообщения или поста упоминания, ссылки и хэштеги, по порядку
+func ParseText(text string) (entities []TextEntity) {
+	var taken [][]int
+
+	add := func(loc []int, e TextEntity) {
+		for _, t := range taken {
+			if loc[0] < t[1] && t[0] < loc[1] {
+				return
+			}
+		}
+		taken = append(taken, loc)
+
+		e.Offset = utf8.RuneCountInString(text[:loc[0]])
+		e.Length = utf8.RuneCountInString(text[loc[0]:loc[1]])
+		e.Text = text[loc[0]:loc[1]]
+		entities = append(entities, e)
+	}
+
+	// [id1|Имя] и [screen_name|Имя]
+	for _, m := range textMentionReg.FindAllStringSubmatchIndex(text, -1) {
+		e := TextEntity{Type: "mention", Name: text[m[8]:m[9]]}
+		if m[4] != -1 {
+			e.OwnerID = mentionOwnerID(text[m[4]:m[5]], text[m[6]:m[7]])
+		} else {
+			e.ScreenName = text[m[2]:m[3]]
+		}
+		add(m[:2], e)
+	}
+
+	// [https://vk.com/page|текст]
+	for _, m := range textNamedLinkReg.FindAllStringSubmatchIndex(text, -1) {
+		add(m[:2], TextEntity{Type: "link", URL: text[m[2]:m[3]], Name: text[m[4]:m[5]]})
+	}
+
+	// @id1 (Имя) и *id1
+	for _, m := range textShortReg.FindAllStringSubmatchIndex(text, -1) {
+		e := TextEntity{Type: "mention", OwnerID: mentionOwnerID(text[m[2]:m[3]], text[m[4]:m[5]])}
+		if m[6] != -1 {
+			e.Name = text[m[6]:m[7]]
+		}
+		add(m[:2], e)
+	}
+
+	for _, m := range textLinkReg.FindAllStringIndex(text, -1) {
+		url := strings.TrimRight(text[m[0]:m[1]], ".,!?;:)")
+		m[1] = m[0] + len(url)
+		if !strings.Contains(strings.ToLower(url), "://") {
+			url = "https://" + url
+		}
+		add(m, TextEntity{Type: "link", URL: url})
+	}
+
+	for _, m := range textHashtagReg.FindAllStringIndex(text, -1) {
+		add(m, TextEntity{Type: "hashtag"})
+	}
+
+	sort.Slice(entities, func(i, j int) bool { return entities[i].Offset < entities[j].Offset })
+	return
+}
+
+// id из упоминания, у сообществ отрицательный
+func mentionOwnerID(kind, id string) int {
+	n, _ := strconv.Atoi(id)
+	if kind != "id" {
+		n = -n
+	}
+	return n
+}
@@ -0,0 +1,446 @@
+package vkapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTokenNotFound - токена нет в хранилище
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokenRevoked - токен отозван
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrTokenExpired - срок действия токена истек
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// TokenRef - чей токен: пользователя или сообщества
+type TokenRef struct {
+	// Kind - user или group
+	Kind string
+	ID   int
+}
+
+// UserTokenRef - ссылка на токен пользователя
+func UserTokenRef(userID int) TokenRef {
+	return TokenRef{Kind: "user", ID: userID}
+}
+
+// GroupTokenRef - ссылка на токен сообщества
+func GroupTokenRef(groupID int) TokenRef {
+	return TokenRef{Kind: "group", ID: groupID}
+}
+
+// String - ключ вида user:1 или group:2
+func (r TokenRef) String() string {
+	return r.Kind + ":" + strconv.Itoa(r.ID)
+}
+
+// ParseTokenRef - разбираем ключ вида user:1
+func ParseTokenRef(str string) (r TokenRef, err error) {
+	arr := strings.SplitN(str, ":", 2)
+	if len(arr) != 2 || (arr[0] != "user" && arr[0] != "group") {
+		err = errors.New("bad token ref: " + str)
+		return
+	}
+
+	r.Kind = arr[0]
+	r.ID, err = strconv.Atoi(arr[1])
+	return
+}
+
+// StoredToken - токен в хранилище
+type StoredToken struct {
+	Ref         TokenRef
+	AccessToken string
+	// ExpiresAt - когда истекает, нулевое время для бессрочных токенов
+	ExpiresAt time.Time
+	// Scope - маска прав (Scope или GroupScope)
+	Scope     int
+	Revoked   bool
+	UpdatedAt time.Time
+}
+
+// Expired - истек ли срок действия
+func (t StoredToken) Expired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)
+}
+
+// NewStoredToken - токен из ответа GetToken
+func NewStoredToken(ref TokenRef, ans GetTokenAns) (t StoredToken) {
+	t = StoredToken{Ref: ref, AccessToken: ans.AccessToken}
+	if ans.ExpiresIn > 0 {
+		t.ExpiresAt = time.Now().Add(time.Duration(ans.ExpiresIn) * time.Second)
+	}
+	return
+}
+
+// TokenStore - хранилище токенов
+type TokenStore interface {
+	// Get - токен по ссылке, ErrTokenNotFound если его нет
+	Get(ref TokenRef) (t StoredToken, err error)
+	Put(t StoredToken) (err error)
+	// Revoke - помечаем токен отозванным
+	Revoke(ref TokenRef) (err error)
+}
+
+// NewAPIFromStore - API с токеном из хранилища
+func NewAPIFromStore(store TokenStore, ref TokenRef) (vk *API, err error) {
+	t, err := store.Get(ref)
+	if err != nil {
+		return
+	}
+	if t.Revoked {
+		err = ErrTokenRevoked
+		return
+	}
+	if t.Expired() {
+		err = ErrTokenExpired
+		return
+	}
+
+	vk = &API{AccessToken: t.AccessToken}
+	return
+}
+
+/*
+	Ключи шифрования
+*/
+
+// TokenKeyring - ключи AES-256-GCM по id, шифруем текущим, расшифровываем любым
+// Шифротекст имеет вид <id ключа>.<base64(nonce+данные)>, id ключа не может содержать точку
+type TokenKeyring struct {
+	Current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewTokenKeyring - создаем набор ключей, current - id ключа для шифрования
+func NewTokenKeyring(current string, keys map[string][]byte) (k *TokenKeyring, err error) {
+	k = &TokenKeyring{Current: current, keys: make(map[string]cipher.AEAD)}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ".") {
+			err = errors.New("bad key id: " + id)
+			return
+		}
+
+		var block cipher.Block
+		block, err = aes.NewCipher(key)
+		if err != nil {
+			return
+		}
+		k.keys[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return
+		}
+	}
+
+	if k.keys[current] == nil {
+		err = errors.New("no current key: " + current)
+		return
+	}
+
+	return
+}
+
+// Encrypt - шифруем текущим ключом, aad привязывает шифротекст к владельцу токена
+func (k *TokenKeyring) Encrypt(plain, aad string) (enc string, err error) {
+	aead := k.keys[k.Current]
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return
+	}
+
+	b := aead.Seal(nonce, nonce, []byte(plain), []byte(aad))
+	enc = k.Current + "." + base64.RawURLEncoding.EncodeToString(b)
+	return
+}
+
+// Decrypt - расшифровываем ключом, id которого указан в шифротексте
+func (k *TokenKeyring) Decrypt(enc, aad string) (plain string, err error) {
+	i := strings.Index(enc, ".")
+	if i == -1 {
+		err = errors.New("bad ciphertext")
+		return
+	}
+
+	aead := k.keys[enc[:i]]
+	if aead == nil {
+		err = errors.New("unknown key: " + enc[:i])
+		return
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(enc[i+1:])
+	if err != nil {
+		return
+	}
+	if len(b) < aead.NonceSize() {
+		err = errors.New("bad ciphertext")
+		return
+	}
+
+	b, err = aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return
+	}
+
+	plain = string(b)
+	return
+}
+
+// KeyID - id ключа, которым зашифрован шифротекст
+func (k *TokenKeyring) KeyID(enc string) string {
+	if i := strings.Index(enc, "."); i != -1 {
+		return enc[:i]
+	}
+	return ""
+}
+
+/*
+	Хранилище в памяти
+*/
+
+// MemoryTokenStore - хранилище токенов в памяти
+type MemoryTokenStore struct {
+	h map[TokenRef]StoredToken
+	sync.RWMutex
+}
+
+// NewMemoryTokenStore - создаем хранилище токенов в памяти
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{h: make(map[TokenRef]StoredToken)}
+}
+
+// Get - токен по ссылке
+func (s *MemoryTokenStore) Get(ref TokenRef) (t StoredToken, err error) {
+	s.RLock()
+	t, ok := s.h[ref]
+	s.RUnlock()
+	if !ok {
+		err = ErrTokenNotFound
+	}
+	return
+}
+
+// Put - сохраняем токен
+func (s *MemoryTokenStore) Put(t StoredToken) (err error) {
+	t.UpdatedAt = time.Now()
+	s.Lock()
+	s.h[t.Ref] = t
+	s.Unlock()
+	return
+}
+
+// Revoke - помечаем токен отозванным
+func (s *MemoryTokenStore) Revoke(ref TokenRef) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	t, ok := s.h[ref]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	t.Revoked = true
+	t.UpdatedAt = time.Now()
+	s.h[ref] = t
+	return
+}
+
+/*
+	Хранилище в файле
+*/
+
+// FileTokenStore - хранилище токенов в JSON файле, токены зашифрованы
+type FileTokenStore struct {
+	path    string
+	keyring *TokenKeyring
+	h       map[string]fileTokenRecord
+	sync.Mutex
+}
+
+type fileTokenRecord struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Scope     int       `json:"scope,omitempty"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OpenFileTokenStore - открываем хранилище, если файла нет - он будет создан при первой записи
+func OpenFileTokenStore(path string, keyring *TokenKeyring) (s *FileTokenStore, err error) {
+	s = &FileTokenStore{path: path, keyring: keyring, h: make(map[string]fileTokenRecord)}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		}
+		log.Println("[error]", err)
+		return
+	}
+
+	err = json.Unmarshal(content, &s.h)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	return
+}
+
+// Get - токен по ссылке
+func (s *FileTokenStore) Get(ref TokenRef) (t StoredToken, err error) {
+	s.Lock()
+	rec, ok := s.h[ref.String()]
+	s.Unlock()
+	if !ok {
+		err = ErrTokenNotFound
+		return
+	}
+
+	t = StoredToken{Ref: ref, ExpiresAt: rec.ExpiresAt, Scope: rec.Scope, Revoked: rec.Revoked, UpdatedAt: rec.UpdatedAt}
+	t.AccessToken, err = s.keyring.Decrypt(rec.Token, ref.String())
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	return
+}
+
+// Put - сохраняем токен
+func (s *FileTokenStore) Put(t StoredToken) (err error) {
+	enc, err := s.keyring.Encrypt(t.AccessToken, t.Ref.String())
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.update(map[string]fileTokenRecord{
+		t.Ref.String(): {Token: enc, ExpiresAt: t.ExpiresAt, Scope: t.Scope, Revoked: t.Revoked, UpdatedAt: time.Now()},
+	})
+}
+
+// Revoke - помечаем токен отозванным
+func (s *FileTokenStore) Revoke(ref TokenRef) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	rec, ok := s.h[ref.String()]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	rec.Revoked = true
+	rec.UpdatedAt = time.Now()
+	return s.update(map[string]fileTokenRecord{ref.String(): rec})
+}
+
+// Refs - все токены в хранилище
+func (s *FileTokenStore) Refs() (refs []TokenRef) {
+	s.Lock()
+	defer s.Unlock()
+
+	for k := range s.h {
+		if r, err := ParseTokenRef(k); err == nil {
+			refs = append(refs, r)
+		}
+	}
+	return
+}
+
+// Reencrypt - перешифровываем текущим ключом токены, зашифрованные старыми ключами
+// После этого старый ключ можно убрать из TokenKeyring
+func (s *FileTokenStore) Reencrypt() (n int, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	changed := make(map[string]fileTokenRecord)
+	for k, rec := range s.h {
+		if s.keyring.KeyID(rec.Token) == s.keyring.Current {
+			continue
+		}
+
+		var plain string
+		plain, err = s.keyring.Decrypt(rec.Token, k)
+		if err != nil {
+			log.Println("[error]", k, err)
+			return
+		}
+
+		rec.Token, err = s.keyring.Encrypt(plain, k)
+		if err != nil {
+			log.Println("[error]", err)
+			return
+		}
+		changed[k] = rec
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	err = s.update(changed)
+	if err != nil {
+		return
+	}
+
+	n = len(changed)
+	return
+}
+
+// ImportLegacy - переносим токен, зашифрованный EncryptToken
+func (s *FileTokenStore) ImportLegacy(ref TokenRef, legacyKey, encToken string) (err error) {
+	token, err := DecryptToken(legacyKey, encToken)
+	if err != nil {
+		return
+	}
+
+	return s.Put(StoredToken{Ref: ref, AccessToken: token})
+}
+
+// Записываем в файл копию с изменениями, s.h меняем только после успешной записи
+// Вызывается под блокировкой
+func (s *FileTokenStore) update(changed map[string]fileTokenRecord) (err error) {
+	h := make(map[string]fileTokenRecord, len(s.h)+len(changed))
+	for k, rec := range s.h {
+		h[k] = rec
+	}
+	for k, rec := range changed {
+		h[k] = rec
+	}
+
+	err = s.save(h)
+	if err != nil {
+		return
+	}
+
+	s.h = h
+	return
+}
+
+// Пишем через временный файл с fsync, чтобы не потерять данные при падении
+func (s *FileTokenStore) save(h map[string]fileTokenRecord) (err error) {
+	b, err := json.Marshal(h)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	return writeFileSync(s.path, b, 0600)
+}
@@ -0,0 +1,91 @@
+package vkapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileTokenStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	k1 := bytes.Repeat([]byte{1}, 32)
+	k2 := bytes.Repeat([]byte{2}, 32)
+
+	kr, err := NewTokenKeyring("k1", map[string][]byte{"k1": k1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenFileTokenStore(path, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(StoredToken{Ref: UserTokenRef(5), AccessToken: "secret-token", Scope: int(ScopeMessages)}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret-token") || !strings.Contains(string(b), `"k1.`) {
+		t.Fatal("token is not encrypted with k1:", string(b))
+	}
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("tmp file left:", err)
+	}
+
+	// Новый ключ: старые токены читаются, Reencrypt переносит их на новый ключ
+	kr, _ = NewTokenKeyring("k2", map[string][]byte{"k1": k1, "k2": k2})
+	s, err = OpenFileTokenStore(path, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Reencrypt(); n != 1 || err != nil {
+		t.Fatal(n, err)
+	}
+
+	kr, _ = NewTokenKeyring("k2", map[string][]byte{"k2": k2})
+	s, err = OpenFileTokenStore(path, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := s.Get(UserTokenRef(5))
+	if err != nil || tok.AccessToken != "secret-token" || tok.Scope != int(ScopeMessages) {
+		t.Fatal(tok, err)
+	}
+
+	if err = s.Revoke(UserTokenRef(5)); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = OpenFileTokenStore(path, kr)
+	if _, err = NewAPIFromStore(s, UserTokenRef(5)); err != ErrTokenRevoked {
+		t.Fatal(err)
+	}
+	if _, err = NewAPIFromStore(s, GroupTokenRef(5)); err != ErrTokenNotFound {
+		t.Fatal(err)
+	}
+
+	// Токен привязан к своей ссылке
+	enc, _ := kr.Encrypt("x", "user:1")
+	if _, err = kr.Decrypt(enc, "user:2"); err == nil {
+		t.Fatal("decrypted with another ref")
+	}
+}
+
+func TestFileTokenStoreSaveError(t *testing.T) {
+	kr, _ := NewTokenKeyring("k", map[string][]byte{"k": make([]byte, 32)})
+	s, err := OpenFileTokenStore(filepath.Join(t.TempDir(), "nodir", "tokens.json"), kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Put(StoredToken{Ref: UserTokenRef(1), AccessToken: "a"}); err == nil {
+		t.Fatal("no error")
+	}
+	if _, err = s.Get(UserTokenRef(1)); err != ErrTokenNotFound {
+		t.Fatal("token kept in memory after failed save:", err)
+	}
+}
@@ -1,10 +1,16 @@
 package vkapi
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"log"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/fe0b6/tools"
@@ -61,6 +67,27 @@ func chunkSliceString(arr []string, size int) (ans [][]string) {
 	return
 }
 
+// Разбиваем массив чисел на несколько
+func chunkSliceInt(arr []int, size int) (ans [][]int) {
+	for len(arr) > size {
+		ans = append(ans, arr[:size])
+		arr = arr[size:]
+	}
+	if len(arr) > 0 {
+		ans = append(ans, arr)
+	}
+	return
+}
+
+// Склеиваем числа через запятую
+func joinInts(arr []int) string {
+	s := make([]string, len(arr))
+	for i, v := range arr {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
+
 // Проверяем надо ли пропустить ошибу
 func (vk *API) checkErrorSkip(str string) bool {
 	for _, e := range vk.ErrorToSkip {
@@ -136,3 +163,82 @@ func DecryptToken(key string, encToken string) (token string, err error) {
 	token = string(b)
 	return
 }
+
+// NewRandomID - случайный random_id для messages.send
+func NewRandomID() int32 {
+	var b [4]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		log.Println("[error]", err)
+	}
+
+	id := int32(binary.LittleEndian.Uint32(b[:]) & 0x7fffffff)
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// RandomIDFromKey - random_id для messages.send вычисленный из ключа идемпотентности
+func RandomIDFromKey(key string) int32 {
+	h := sha256.Sum256([]byte(key))
+
+	id := int32(binary.LittleEndian.Uint32(h[:4]) & 0x7fffffff)
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// Копируем параметры запроса, чтобы не менять переданную карту
+func copyParams(params map[string]string) map[string]string {
+	p := make(map[string]string, len(params)+1)
+	for k, v := range params {
+		p[k] = v
+	}
+	return p
+}
+
+// Пишем файл через временный с fsync файла и каталога, после падения останется старая или новая версия
+func writeFileSync(path string, b []byte, perm os.FileMode) (err error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Println("[error]", err)
+		os.Remove(tmp)
+		return
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	// Без fsync каталога переименование может не пережить отключение питания
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+	err = d.Sync()
+	d.Close()
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	return
+}
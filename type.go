@@ -28,7 +28,15 @@ type contextMap struct {
 
 // API - главный объект
 type API struct {
-	AccessToken    string
+	AccessToken string
+	// Pool - токены пользователей, используются если AccessToken пустой
+	Pool *TokenPool
+	// ServiceToken - сервисный токен приложения для методов из ServiceTokenMethods
+	ServiceToken string
+	// Captcha - решение капчи, если nil то запрос с капчей сразу возвращает *CaptchaError
+	Captcha CaptchaSolver
+	// Validation - обработка ошибок 17 и 24, если nil то запрос сразу возвращает *ValidationError
+	Validation     ValidationHandler
 	retryCount     int
 	httpRetryCount int
 	ErrorToSkip    []string
@@ -43,9 +51,20 @@ type AuthURLData struct {
 	RedirectURI string
 	GroupIDs    string
 	Display     string
+	// Scope - права строкой, к ним добавляются UserScopes или GroupScopes
 	Scope       string
+	UserScopes  Scope
+	GroupScopes GroupScope
 	V           float64
 	State       string
+	// ResponseType - code (по умолчанию) или token для implicit flow
+	ResponseType string
+	// CodeChallenge - PKCE, метод всегда S256
+	CodeChallenge string
+	// Revoke - заново запросить права, даже если они уже выданы
+	Revoke bool
+	// AuthURL - адрес авторизации, по умолчанию APIAuthURL
+	AuthURL string
 }
 
 // TokenData - объект получения токена
@@ -55,6 +74,12 @@ type TokenData struct {
 	Code         string
 	RedirectURI  string
 	IsGroup      bool
+	// CodeVerifier - PKCE, если ссылка авторизации была с CodeChallenge
+	CodeVerifier string
+	// GrantType - client_credentials для сервисного токена, тогда Code и RedirectURI не нужны
+	GrantType string
+	// TokenURL - адрес получения токена, по умолчанию APITokenURL
+	TokenURL string
 }
 
 // Response - объект ответа VK
@@ -76,6 +101,12 @@ type ResponseError struct {
 	ErrorCode     int                 `json:"error_code"`
 	ErrorMsg      string              `json:"error_msg"`
 	RequestParams []map[string]string `json:"request_params"`
+	CaptchaSid    string              `json:"captcha_sid"`
+	CaptchaImg    string              `json:"captcha_img"`
+	// RedirectURI - страница проверки для ошибки 17
+	RedirectURI string `json:"redirect_uri"`
+	// ConfirmationText - текст подтверждения для ошибки 24
+	ConfirmationText string `json:"confirmation_text"`
 }
 
 /*
@@ -87,10 +118,32 @@ type GetTokenAns struct {
 	AccessToken      string `json:"access_token"`
 	ExpiresIn        int    `json:"expires_in"`
 	UserID           int    `json:"user_id"`
+	Email            string `json:"email"`
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description"`
 }
 
+// GetTokenGroupAns - объект ответа при получении токенов сообществ
+type GetTokenGroupAns struct {
+	// Tokens - токен по id сообщества
+	Tokens           map[int]string
+	ExpiresIn        int
+	Error            string
+	ErrorDescription string
+}
+
+/*
+	Secure
+*/
+
+// SecureCheckTokenAns - объект ответа при проверке токена
+type SecureCheckTokenAns struct {
+	Success int   `json:"success"`
+	UserID  int   `json:"user_id"`
+	Date    int64 `json:"date"`
+	Expire  int64 `json:"expire"`
+}
+
 /*
 	Users
 */
@@ -607,6 +660,8 @@ type Attachments struct {
 	Sticker     *json.RawMessage `json:"sticker"`
 	PrettyCards *json.RawMessage `json:"pretty_cards"`
 	Market      *json.RawMessage `json:"market"`
+	// Typed - аттач, разобранный один раз при разборе JSON, nil если Attachments собран вручную
+	Typed *TypedAttachment `json:"-"`
 }
 
 // AttachmentsLink - объект ссылки аатача
@@ -622,15 +677,16 @@ type AttachmentsLink struct {
 
 // AttachmentsDoc - объект документа аатача
 type AttachmentsDoc struct {
-	ID      int              `json:"id"`
-	OwnerID int              `json:"owner_id"`
-	Title   string           `json:"title"`
-	Size    int              `json:"size"`
-	Ext     string           `json:"ext"`
-	URL     string           `json:"url"`
-	Date    int64            `json:"date"`
-	Type    int              `json:"type"`
-	Preview *json.RawMessage `json:"preview"`
+	ID        int              `json:"id"`
+	OwnerID   int              `json:"owner_id"`
+	Title     string           `json:"title"`
+	Size      int              `json:"size"`
+	Ext       string           `json:"ext"`
+	URL       string           `json:"url"`
+	Date      int64            `json:"date"`
+	Type      int              `json:"type"`
+	Preview   *json.RawMessage `json:"preview"`
+	AccessKey string           `json:"access_key"`
 }
 
 // AttachmentsPage - объект документа аатача
@@ -666,94 +722,220 @@ type AttachmentsPrettyCardsCards struct {
 	Title   string `json:"title"`
 }
 
+// AttachmentsAudio - объект аудиозаписи аттача
+type AttachmentsAudio struct {
+	ID        int    `json:"id"`
+	OwnerID   int    `json:"owner_id"`
+	Artist    string `json:"artist"`
+	Title     string `json:"title"`
+	Duration  int    `json:"duration"`
+	URL       string `json:"url"`
+	Date      int64  `json:"date"`
+	AccessKey string `json:"access_key"`
+}
+
+// AttachmentsAudioMessage - объект голосового сообщения аттача
+type AttachmentsAudioMessage struct {
+	ID         int    `json:"id"`
+	OwnerID    int    `json:"owner_id"`
+	Duration   int    `json:"duration"`
+	Waveform   []int  `json:"waveform"`
+	LinkOgg    string `json:"link_ogg"`
+	LinkMp3    string `json:"link_mp3"`
+	Transcript string `json:"transcript"`
+	AccessKey  string `json:"access_key"`
+}
+
+// AttachmentsSticker - объект стикера аттача
+type AttachmentsSticker struct {
+	ProductID            int                       `json:"product_id"`
+	StickerID            int                       `json:"sticker_id"`
+	Images               []AttachmentsStickerImage `json:"images"`
+	ImagesWithBackground []AttachmentsStickerImage `json:"images_with_background"`
+	AnimationURL         string                    `json:"animation_url"`
+}
+
+// AttachmentsStickerImage - объект картинки стикера
+type AttachmentsStickerImage struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// AttachmentsNote - объект заметки аттача
+type AttachmentsNote struct {
+	ID       int    `json:"id"`
+	OwnerID  int    `json:"owner_id"`
+	Title    string `json:"title"`
+	Text     string `json:"text"`
+	Date     int64  `json:"date"`
+	Comments int    `json:"comments"`
+	ViewURL  string `json:"view_url"`
+}
+
+// AttachmentsGraffiti - объект граффити аттача
+type AttachmentsGraffiti struct {
+	ID        int    `json:"id"`
+	OwnerID   int    `json:"owner_id"`
+	URL       string `json:"url"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	AccessKey string `json:"access_key"`
+}
+
+// AttachmentsArticle - объект статьи аттача
+type AttachmentsArticle struct {
+	ID        int           `json:"id"`
+	OwnerID   int           `json:"owner_id"`
+	OwnerName string        `json:"owner_name"`
+	Title     string        `json:"title"`
+	Subtitle  string        `json:"subtitle"`
+	State     string        `json:"state"`
+	URL       string        `json:"url"`
+	ViewURL   string        `json:"view_url"`
+	Photo     PhotosGetItem `json:"photo"`
+	AccessKey string        `json:"access_key"`
+}
+
+// AttachmentsEvent - объект встречи аттача
+type AttachmentsEvent struct {
+	ID           int    `json:"id"`
+	Time         int64  `json:"time"`
+	MemberStatus int    `json:"member_status"`
+	IsFavorite   bool   `json:"is_favorite"`
+	Address      string `json:"address"`
+	Text         string `json:"text"`
+	ButtonText   string `json:"button_text"`
+	Friends      []int  `json:"friends"`
+}
+
+// AttachmentsMarketAlbum - объект подборки товаров аттача
+type AttachmentsMarketAlbum struct {
+	ID          int           `json:"id"`
+	OwnerID     int           `json:"owner_id"`
+	Title       string        `json:"title"`
+	Count       int           `json:"count"`
+	Photo       PhotosGetItem `json:"photo"`
+	UpdatedTime int64         `json:"updated_time"`
+}
+
 // GetPrettyCards - Преобрахуем данные карточек в объекты
 func (a *Attachments) GetPrettyCards() (t AttachmentsPrettyCards) {
-	err := json.Unmarshal(*a.PrettyCards, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.PrettyCards != nil {
+			t = *a.Typed.PrettyCards
+		}
 		return
 	}
 
+	a.decode(a.PrettyCards, &t)
 	return
 }
 
 // GetLink - Преобразуем данные ссылки в объект
 func (a *Attachments) GetLink() (t AttachmentsLink) {
-	err := json.Unmarshal(*a.Link, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.Link != nil {
+			t = *a.Typed.Link
+		}
 		return
 	}
 
+	a.decode(a.Link, &t)
 	return
 }
 
 // GetPhoto - Преобразуем данные фото в объект
 func (a *Attachments) GetPhoto() (t PhotosGetItem) {
-	err := json.Unmarshal(*a.Photo, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.Photo != nil {
+			t = *a.Typed.Photo
+		}
 		return
 	}
 
+	a.decode(a.Photo, &t)
 	return
 }
 
 // GetDoc - Преобразуем данные документа в объект
 func (a *Attachments) GetDoc() (t AttachmentsDoc) {
-	err := json.Unmarshal(*a.Doc, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.Doc != nil {
+			t = *a.Typed.Doc
+		}
 		return
 	}
 
+	a.decode(a.Doc, &t)
 	return
 }
 
 // GetVideo - Преобразуем данные видео в объект
 func (a *Attachments) GetVideo() (t VideoGetItem) {
-	err := json.Unmarshal(*a.Video, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.Video != nil {
+			t = *a.Typed.Video
+		}
 		return
 	}
 
+	a.decode(a.Video, &t)
 	return
 }
 
 // GetPage - Преобразуем данные видео в объект
 func (a *Attachments) GetPage() (t AttachmentsPage) {
-	err := json.Unmarshal(*a.Page, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.Page != nil {
+			t = *a.Typed.Page
+		}
 		return
 	}
 
+	a.decode(a.Page, &t)
 	return
 }
 
 // GetPoll - Преобразуем данные видео в объект
 func (a *Attachments) GetPoll() (t PollItem) {
-	err := json.Unmarshal(*a.Poll, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.Poll != nil {
+			t = *a.Typed.Poll
+		}
 		return
 	}
 
+	a.decode(a.Poll, &t)
 	return
 }
 
 // GetMarket - Преобразуем данные товара в объект
 func (a *Attachments) GetMarket() (t MarketGetByIDAns) {
-	err := json.Unmarshal(*a.Market, &t)
-	if err != nil {
-		log.Println("[error]", err)
+	if a.Typed != nil {
+		if a.Typed.Market != nil {
+			t = *a.Typed.Market
+		}
 		return
 	}
 
+	a.decode(a.Market, &t)
 	return
 }
 
+// Разбираем аттач, собранный вручную. Если аттач другого типа - оставляем пустой объект
+func (a *Attachments) decode(raw *json.RawMessage, t interface{}) {
+	if raw == nil {
+		return
+	}
+
+	err := json.Unmarshal(*raw, t)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+}
+
 // CommentData - объект комментариев
 type CommentData struct {
 	Count         int  `json:"count"`
@@ -978,19 +1160,20 @@ type MultiPhotosGetAns struct {
 
 // PhotosGetItem - объект фотографии
 type PhotosGetItem struct {
-	ID       int          `json:"id"`
-	AlbumID  int          `json:"album_id"`
-	OwnerID  int          `json:"owner_id"`
-	UserID   int          `json:"user_id"`
-	Text     string       `json:"text"`
-	Date     int64        `json:"date"`
-	Width    int          `json:"width"`
-	Height   int          `json:"height"`
-	PostID   int          `json:"post_id"`
-	Likes    LikeData     `json:"likes"`
-	Reposts  LikeData     `json:"reposts"`
-	Comments CommentData  `json:"comments"`
-	Sizes    []PhotoSizes `json:"sizes"`
+	ID        int          `json:"id"`
+	AlbumID   int          `json:"album_id"`
+	OwnerID   int          `json:"owner_id"`
+	UserID    int          `json:"user_id"`
+	Text      string       `json:"text"`
+	Date      int64        `json:"date"`
+	Width     int          `json:"width"`
+	Height    int          `json:"height"`
+	PostID    int          `json:"post_id"`
+	Likes     LikeData     `json:"likes"`
+	Reposts   LikeData     `json:"reposts"`
+	Comments  CommentData  `json:"comments"`
+	Sizes     []PhotoSizes `json:"sizes"`
+	AccessKey string       `json:"access_key"`
 }
 
 // PhotoSizes - объект размеров фоток
@@ -1059,6 +1242,7 @@ type VideoGetItem struct {
 	Platform   string   `json:"platform"`
 	Player     string   `json:"player"`
 	AddingDate int64    `json:"adding_date"`
+	AccessKey  string   `json:"access_key"`
 }
 
 // VideoGetCommentsAns - объект списка комментариев
@@ -1074,6 +1258,40 @@ type MultiVideoGetCommentsAns struct {
 	RqData []map[string]interface{} `json:"rq_data"`
 }
 
+// VideoSaveAns - объект ответа при получении адреса загрузки видео
+type VideoSaveAns struct {
+	UploadURL   string `json:"upload_url"`
+	VideoID     int    `json:"video_id"`
+	OwnerID     int    `json:"owner_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	AccessKey   string `json:"access_key"`
+}
+
+/*
+	Docs
+*/
+
+// DocsSaveAns - объект сохраненного документа
+type DocsSaveAns struct {
+	Type         string           `json:"type"`
+	Doc          AttachmentsDoc   `json:"doc"`
+	AudioMessage *json.RawMessage `json:"audio_message"`
+	Graffiti     *json.RawMessage `json:"graffiti"`
+}
+
+/*
+	Upload
+*/
+
+// UploadServerAns - объект адреса загрузки
+type UploadServerAns struct {
+	UploadURL string `json:"upload_url"`
+	AlbumID   int    `json:"album_id"`
+	UserID    int    `json:"user_id"`
+	GroupID   int    `json:"group_id"`
+}
+
 /*
 	Message
 */
@@ -1092,6 +1310,12 @@ type MessagesGetAns struct {
 	Payload     string               `json:"payload"`
 	FwdMessages []MessagesGetAns     `json:"fwd_messages"`
 	Action      MessagesGetAnsAction `json:"action"`
+
+	ConversationMessageID int             `json:"conversation_message_id"`
+	Out                   int             `json:"out"`
+	UpdateTime            int64           `json:"update_time"`
+	IsHidden              bool            `json:"is_hidden"`
+	ReplyMessage          *MessagesGetAns `json:"reply_message"`
 }
 
 // MessagesGetAnsGeo - объект места в сообщении
@@ -1127,6 +1351,143 @@ type MessagesIsMessagesFromGroupAllowedAns struct {
 	IsAllowed int `json:"is_allowed"`
 }
 
+// MessagesSendPeerAns - результат отправки одному получателю при отправке через peer_ids
+type MessagesSendPeerAns struct {
+	PeerID                int                    `json:"peer_id"`
+	MessageID             int                    `json:"message_id"`
+	ConversationMessageID int                    `json:"conversation_message_id"`
+	Error                 *MessagesSendPeerError `json:"error"`
+}
+
+// MessagesSendPeerError - ошибка отправки одному получателю
+type MessagesSendPeerError struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+// MessagesConversation - объект беседы
+type MessagesConversation struct {
+	Peer            MessagesConversationPeer     `json:"peer"`
+	InRead          int                          `json:"in_read"`
+	OutRead         int                          `json:"out_read"`
+	UnreadCount     int                          `json:"unread_count"`
+	Important       bool                         `json:"important"`
+	Unanswered      bool                         `json:"unanswered"`
+	LastMessageID   int                          `json:"last_message_id"`
+	CanWrite        MessagesConversationCanWrite `json:"can_write"`
+	PushSettings    MessagesConversationPush     `json:"push_settings"`
+	ChatSettings    MessagesChatSettings         `json:"chat_settings"`
+	IsMarkedUnread  bool                         `json:"is_marked_unread"`
+	SortID          map[string]int               `json:"sort_id"`
+	CurrentKeyboard *Keyboard                    `json:"current_keyboard"`
+}
+
+// MessagesConversationPeer - собеседник: user, chat, group или email
+type MessagesConversationPeer struct {
+	ID      int    `json:"id"`
+	Type    string `json:"type"`
+	LocalID int    `json:"local_id"`
+}
+
+// MessagesConversationCanWrite - можно ли писать в беседу
+type MessagesConversationCanWrite struct {
+	Allowed bool `json:"allowed"`
+	Reason  int  `json:"reason"`
+}
+
+// MessagesConversationPush - настройки уведомлений беседы
+type MessagesConversationPush struct {
+	DisabledUntil   int64 `json:"disabled_until"`
+	DisabledForever bool  `json:"disabled_forever"`
+	NoSound         bool  `json:"no_sound"`
+}
+
+// MessagesChatSettings - настройки чата
+type MessagesChatSettings struct {
+	MembersCount   int               `json:"members_count"`
+	Title          string            `json:"title"`
+	PinnedMessage  *MessagesGetAns   `json:"pinned_message"`
+	State          string            `json:"state"`
+	Photo          map[string]string `json:"photo"`
+	ActiveIDs      []int             `json:"active_ids"`
+	IsGroupChannel bool              `json:"is_group_channel"`
+	OwnerID        int               `json:"owner_id"`
+	AdminIDs       []int             `json:"admin_ids"`
+}
+
+// MessagesGetConversationsAns - объект ответа при запросе списка бесед
+type MessagesGetConversationsAns struct {
+	Count       int                            `json:"count"`
+	UnreadCount int                            `json:"unread_count"`
+	Items       []MessagesGetConversationsItem `json:"items"`
+	Profiles    []UsersGetAns                  `json:"profiles"`
+	Groups      []GroupsGetByIDAns             `json:"groups"`
+}
+
+// MessagesGetConversationsItem - беседа с последним сообщением
+type MessagesGetConversationsItem struct {
+	Conversation MessagesConversation `json:"conversation"`
+	LastMessage  MessagesGetAns       `json:"last_message"`
+}
+
+// MessagesGetHistoryAns - объект истории сообщений
+type MessagesGetHistoryAns struct {
+	Count         int                    `json:"count"`
+	Items         []MessagesGetAns       `json:"items"`
+	Profiles      []UsersGetAns          `json:"profiles"`
+	Groups        []GroupsGetByIDAns     `json:"groups"`
+	Conversations []MessagesConversation `json:"conversations"`
+}
+
+// MessagesGetByIDAns - объект списка сообщений
+type MessagesGetByIDAns struct {
+	Count    int                `json:"count"`
+	Items    []MessagesGetAns   `json:"items"`
+	Profiles []UsersGetAns      `json:"profiles"`
+	Groups   []GroupsGetByIDAns `json:"groups"`
+}
+
+// MessagesGetConversationMembersAns - объект списка участников беседы
+type MessagesGetConversationMembersAns struct {
+	Count    int                              `json:"count"`
+	Items    []MessagesConversationMemberItem `json:"items"`
+	Profiles []UsersGetAns                    `json:"profiles"`
+	Groups   []GroupsGetByIDAns               `json:"groups"`
+}
+
+// MessagesConversationMemberItem - участник беседы
+type MessagesConversationMemberItem struct {
+	MemberID  int   `json:"member_id"`
+	InvitedBy int   `json:"invited_by"`
+	JoinDate  int64 `json:"join_date"`
+	IsAdmin   bool  `json:"is_admin"`
+	IsOwner   bool  `json:"is_owner"`
+	CanKick   bool  `json:"can_kick"`
+}
+
+// MessagesSearchConversationsAns - объект результата поиска бесед
+type MessagesSearchConversationsAns struct {
+	Count    int                    `json:"count"`
+	Items    []MessagesConversation `json:"items"`
+	Profiles []UsersGetAns          `json:"profiles"`
+	Groups   []GroupsGetByIDAns     `json:"groups"`
+}
+
+// MessagesGetInviteLinkAns - объект ссылки приглашения в беседу
+type MessagesGetInviteLinkAns struct {
+	Link string `json:"link"`
+}
+
+// MessageEventData - действие при ответе на нажатие callback кнопки
+type MessageEventData struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitempty"`
+	Link    string `json:"link,omitempty"`
+	AppID   int    `json:"app_id,omitempty"`
+	OwnerID int    `json:"owner_id,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+}
+
 /*
 	Market
 */
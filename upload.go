@@ -0,0 +1,435 @@
+package vkapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Ограничения ВК на загружаемые файлы
+const (
+	UploadPhotoMaxSize   = 50 << 20
+	UploadDocMaxSize     = 200 << 20
+	UploadAlbumMaxPhotos = 5
+)
+
+var (
+	uploadPhotoTypes = map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+	}
+	uploadVideoExt = map[string]bool{
+		".avi": true, ".mp4": true, ".3gp": true, ".mpeg": true, ".mpg": true,
+		".mov": true, ".flv": true, ".wmv": true, ".mkv": true, ".webm": true,
+	}
+	uploadDocForbiddenExt = map[string]bool{
+		".exe": true, ".bat": true, ".cmd": true, ".com": true, ".scr": true,
+		".msi": true, ".vbs": true,
+	}
+)
+
+// UploadFile - файл для загрузки
+type UploadFile struct {
+	// Name - имя файла с расширением
+	Name   string
+	Reader io.Reader
+}
+
+// Ответ сервера загрузки
+type uploadAns struct {
+	Server     int    `json:"server"`
+	Photo      string `json:"photo"`
+	PhotosList string `json:"photos_list"`
+	AID        int    `json:"aid"`
+	Hash       string `json:"hash"`
+	File       string `json:"file"`
+	VideoID    int    `json:"video_id"`
+	OwnerID    int    `json:"owner_id"`
+	Size       int64  `json:"size"`
+	Error      string `json:"error"`
+}
+
+/*
+	Фото
+*/
+
+// UploadMessagesPhoto - загружаем фото для отправки в сообщении
+func (vk *API) UploadMessagesPhoto(peerID int, f UploadFile) (attachment string, photo PhotosGetItem, err error) {
+	srv, err := vk.PhotosGetMessagesUploadServer(map[string]string{"peer_id": strconv.Itoa(peerID)})
+	if err != nil {
+		return
+	}
+
+	ans, err := uploadToServer(srv.UploadURL, []string{"photo"}, []UploadFile{f}, validatePhoto, UploadPhotoMaxSize)
+	if err != nil {
+		return
+	}
+
+	photos, err := vk.PhotosSaveMessagesPhoto(map[string]string{
+		"photo":  ans.Photo,
+		"server": strconv.Itoa(ans.Server),
+		"hash":   ans.Hash,
+	})
+	if err != nil {
+		return
+	}
+
+	return firstPhoto(photos)
+}
+
+// UploadWallPhoto - загружаем фото для поста на стене, groupID 0 - стена пользователя
+func (vk *API) UploadWallPhoto(groupID int, f UploadFile) (attachment string, photo PhotosGetItem, err error) {
+	params := map[string]string{}
+	if groupID != 0 {
+		params["group_id"] = strconv.Itoa(groupID)
+	}
+
+	srv, err := vk.PhotosGetWallUploadServer(params)
+	if err != nil {
+		return
+	}
+
+	ans, err := uploadToServer(srv.UploadURL, []string{"photo"}, []UploadFile{f}, validatePhoto, UploadPhotoMaxSize)
+	if err != nil {
+		return
+	}
+
+	params["photo"] = ans.Photo
+	params["server"] = strconv.Itoa(ans.Server)
+	params["hash"] = ans.Hash
+	photos, err := vk.PhotosSaveWallPhoto(params)
+	if err != nil {
+		return
+	}
+
+	return firstPhoto(photos)
+}
+
+// UploadAlbumPhotos - загружаем до 5 фото в альбом, groupID 0 - альбом пользователя
+func (vk *API) UploadAlbumPhotos(albumID, groupID int, files []UploadFile) (attachments []string, photos []PhotosGetItem, err error) {
+	if len(files) == 0 || len(files) > UploadAlbumMaxPhotos {
+		err = fmt.Errorf("can upload 1-%d photos at once", UploadAlbumMaxPhotos)
+		log.Println("[error]", err)
+		return
+	}
+
+	params := map[string]string{"album_id": strconv.Itoa(albumID)}
+	if groupID != 0 {
+		params["group_id"] = strconv.Itoa(groupID)
+	}
+
+	srv, err := vk.PhotosGetUploadServer(params)
+	if err != nil {
+		return
+	}
+
+	fields := make([]string, len(files))
+	for i := range files {
+		fields[i] = "file" + strconv.Itoa(i+1)
+	}
+
+	ans, err := uploadToServer(srv.UploadURL, fields, files, validatePhoto, UploadPhotoMaxSize)
+	if err != nil {
+		return
+	}
+
+	params["server"] = strconv.Itoa(ans.Server)
+	params["photos_list"] = ans.PhotosList
+	params["hash"] = ans.Hash
+	photos, err = vk.PhotosSave(params)
+	if err != nil {
+		return
+	}
+
+	attachments = make([]string, len(photos))
+	for i, p := range photos {
+		attachments[i] = AttachmentRef{Type: "photo", OwnerID: p.OwnerID, ID: p.ID, AccessKey: p.AccessKey}.String()
+	}
+	return
+}
+
+func firstPhoto(photos []PhotosGetItem) (attachment string, photo PhotosGetItem, err error) {
+	if len(photos) == 0 {
+		err = errors.New("no photo saved")
+		log.Println("[error]", err)
+		return
+	}
+
+	photo = photos[0]
+	attachment = AttachmentRef{Type: "photo", OwnerID: photo.OwnerID, ID: photo.ID, AccessKey: photo.AccessKey}.String()
+	return
+}
+
+/*
+	Документы
+*/
+
+// UploadMessagesDoc - загружаем документ для отправки в сообщении
+// docType - doc, audio_message или graffiti
+func (vk *API) UploadMessagesDoc(peerID int, docType string, f UploadFile, title string) (attachment string, doc DocsSaveAns, err error) {
+	params := map[string]string{"peer_id": strconv.Itoa(peerID)}
+	if docType != "" {
+		params["type"] = docType
+	}
+
+	srv, err := vk.DocsGetMessagesUploadServer(params)
+	if err != nil {
+		return
+	}
+
+	return vk.uploadDoc(srv.UploadURL, f, title)
+}
+
+// UploadWallDoc - загружаем документ для поста на стене, groupID 0 - стена пользователя
+func (vk *API) UploadWallDoc(groupID int, f UploadFile, title string) (attachment string, doc DocsSaveAns, err error) {
+	params := map[string]string{}
+	if groupID != 0 {
+		params["group_id"] = strconv.Itoa(groupID)
+	}
+
+	srv, err := vk.DocsGetWallUploadServer(params)
+	if err != nil {
+		return
+	}
+
+	return vk.uploadDoc(srv.UploadURL, f, title)
+}
+
+func (vk *API) uploadDoc(uploadURL string, f UploadFile, title string) (attachment string, doc DocsSaveAns, err error) {
+	ans, err := uploadToServer(uploadURL, []string{"file"}, []UploadFile{f}, validateDoc, UploadDocMaxSize)
+	if err != nil {
+		return
+	}
+
+	params := map[string]string{"file": ans.File}
+	if title != "" {
+		params["title"] = title
+	}
+
+	doc, err = vk.DocsSave(params)
+	if err != nil {
+		return
+	}
+
+	// Голосовые сообщения и граффити - тоже документы
+	attachment = AttachmentRef{Type: "doc", OwnerID: doc.Doc.OwnerID, ID: doc.Doc.ID, AccessKey: doc.Doc.AccessKey}.String()
+	if doc.Type != "doc" && doc.Type != "" {
+		var d AttachmentsDoc
+		var raw *json.RawMessage
+		if doc.Type == "audio_message" {
+			raw = doc.AudioMessage
+		} else {
+			raw = doc.Graffiti
+		}
+		if raw != nil && json.Unmarshal(*raw, &d) == nil {
+			attachment = AttachmentRef{Type: "doc", OwnerID: d.OwnerID, ID: d.ID, AccessKey: d.AccessKey}.String()
+		}
+	}
+
+	return
+}
+
+/*
+	Видео
+*/
+
+// UploadVideo - загружаем видео, params - параметры video.save (name, description, group_id ...)
+func (vk *API) UploadVideo(params map[string]string, f UploadFile) (attachment string, video VideoSaveAns, err error) {
+	err = validateVideo(f.Name, nil)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	video, err = vk.VideoSave(params)
+	if err != nil {
+		return
+	}
+
+	ans, err := uploadToServer(video.UploadURL, []string{"video_file"}, []UploadFile{f}, nil, 0)
+	if err != nil {
+		return
+	}
+
+	if ans.VideoID != 0 {
+		video.VideoID = ans.VideoID
+	}
+	if ans.OwnerID != 0 {
+		video.OwnerID = ans.OwnerID
+	}
+
+	attachment = AttachmentRef{Type: "video", OwnerID: video.OwnerID, ID: video.VideoID, AccessKey: video.AccessKey}.String()
+	return
+}
+
+/*
+	Загрузка
+*/
+
+// Проверка файла по имени и первым байтам
+type uploadValidator func(name string, head []byte) error
+
+func validatePhoto(name string, head []byte) (err error) {
+	ct := http.DetectContentType(head)
+	if !uploadPhotoTypes[ct] {
+		err = fmt.Errorf("%s: unsupported photo type %s", name, ct)
+	}
+	return
+}
+
+func validateDoc(name string, head []byte) (err error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" || uploadDocForbiddenExt[ext] {
+		err = fmt.Errorf("%s: unsupported document extension", name)
+	}
+	return
+}
+
+func validateVideo(name string, head []byte) (err error) {
+	if !uploadVideoExt[strings.ToLower(filepath.Ext(name))] {
+		err = fmt.Errorf("%s: unsupported video extension", name)
+	}
+	return
+}
+
+// Отправляем файлы multipart запросом на сервер загрузки
+// Файлы не читаются в память целиком, а передаются потоком
+// maxSize - максимальный размер каждого файла, 0 - без ограничения
+func uploadToServer(uploadURL string, fields []string, files []UploadFile, validate uploadValidator, maxSize int64) (ans uploadAns, err error) {
+	if uploadURL == "" {
+		err = errors.New("empty upload url")
+		log.Println("[error]", err)
+		return
+	}
+
+	// Проверяем тип по первым байтам
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		if f.Reader == nil {
+			err = errors.New(f.Name + ": no file reader")
+			log.Println("[error]", err)
+			return
+		}
+
+		head := make([]byte, 512)
+		var n int
+		n, err = io.ReadFull(f.Reader, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			log.Println("[error]", err)
+			return
+		}
+		err = nil
+		head = head[:n]
+
+		if n == 0 {
+			err = errors.New(f.Name + ": empty file")
+			log.Println("[error]", err)
+			return
+		}
+
+		if validate != nil {
+			err = validate(f.Name, head)
+			if err != nil {
+				log.Println("[error]", err)
+				return
+			}
+		}
+
+		readers[i] = io.MultiReader(bytes.NewReader(head), f.Reader)
+		if maxSize > 0 {
+			readers[i] = &limitedReader{r: readers[i], left: maxSize, name: f.Name}
+		}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		var werr error
+		for i, f := range files {
+			var part io.Writer
+			part, werr = mw.CreateFormFile(fields[i], filepath.Base(f.Name))
+			if werr != nil {
+				break
+			}
+			_, werr = io.Copy(part, readers[i])
+			if werr != nil {
+				break
+			}
+		}
+		if werr == nil {
+			werr = mw.Close()
+		}
+		pw.CloseWithError(werr)
+	}()
+
+	req, err := http.NewRequest("POST", uploadURL, pr)
+	if err != nil {
+		log.Println("[error]", err)
+		pr.CloseWithError(err)
+		return
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	client := &http.Client{Transport: httpTr}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	if resp.StatusCode != 200 {
+		err = errors.New(resp.Status)
+		log.Println("[error]", resp.Status, resp.StatusCode)
+		return
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	err = json.Unmarshal(content, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(content))
+		return
+	}
+
+	if ans.Error != "" {
+		err = errors.New(ans.Error)
+		log.Println("[error]", err)
+		return
+	}
+
+	return
+}
+
+// Ограничиваем размер передаваемого файла
+type limitedReader struct {
+	r    io.Reader
+	left int64
+	name string
+}
+
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	n, err = l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		err = fmt.Errorf("%s: file is too big", l.name)
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package vkapi
+
+import "log"
+
+const (
+	// ValidationMaxAttempts - сколько раз повторяем запрос после проверки пользователем
+	ValidationMaxAttempts = 2
+)
+
+// ValidationError - ВК требует проверку пользователем (ошибка 17) или подтверждение действия (ошибка 24)
+type ValidationError struct {
+	Method string
+	Code   int
+	// RedirectURI - страница, которую надо открыть пользователю (ошибка 17)
+	RedirectURI string
+	// ConfirmationText - что подтверждает пользователь (ошибка 24)
+	ConfirmationText string
+	msg              string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+// NeedConfirmation - нужно подтверждение действия, запрос повторяется с confirm=1
+func (e *ValidationError) NeedConfirmation() bool {
+	return e.Code == 24
+}
+
+// ValidationHandler - показывает пользователю проверку и ждет ее прохождения
+// nil - запрос повторяется, ошибка - запрос возвращает ее
+type ValidationHandler interface {
+	Validate(e *ValidationError) (err error)
+}
+
+// ValidationHandlerFunc - функция как ValidationHandler
+type ValidationHandlerFunc func(e *ValidationError) (err error)
+
+// Validate - вызываем функцию
+func (f ValidationHandlerFunc) Validate(e *ValidationError) (err error) {
+	return f(e)
+}
+
+// Отдаем проверку обработчику, без обработчика или после ValidationMaxAttempts попыток возвращаем *ValidationError
+func (vk *API) validate(method string, rerr ResponseError, attempt int) (err error) {
+	verr := &ValidationError{
+		Method:           method,
+		Code:             rerr.ErrorCode,
+		RedirectURI:      rerr.RedirectURI,
+		ConfirmationText: rerr.ConfirmationText,
+		msg:              rerr.ErrorMsg,
+	}
+	if vk.Validation == nil || attempt > ValidationMaxAttempts {
+		return verr
+	}
+
+	err = vk.Validation.Validate(verr)
+	if err != nil {
+		log.Println("[error]", method, err)
+		return
+	}
+
+	return
+}
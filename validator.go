@@ -0,0 +1,252 @@
+package vkapi
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenExpiryWarning - за сколько до истечения токена сообщаем о нем по умолчанию
+	TokenExpiryWarning = 24 * time.Hour
+	// TokenCheckInterval - как часто проверяем токены в фоне по умолчанию
+	TokenCheckInterval = time.Hour
+)
+
+// Типы событий проверки токена
+const (
+	TokenEventValid    = "valid"
+	TokenEventExpiring = "expiring"
+	TokenEventExpired  = "expired"
+	TokenEventRevoked  = "revoked"
+	TokenEventError    = "error"
+)
+
+// TokenStatus - результат проверки токена
+type TokenStatus struct {
+	Ref   TokenRef
+	Valid bool
+	// ExpiresAt - когда истекает, нулевое время для бессрочных токенов
+	ExpiresAt time.Time
+	// Scope - маска прав, 0 если проверка ее не вернула
+	Scope     int
+	CheckedAt time.Time
+}
+
+// TokenEvent - событие проверки токена
+type TokenEvent struct {
+	Type   string
+	Status TokenStatus
+	// Err - ошибка проверки для TokenEventError
+	Err error
+}
+
+// TokenValidator - проверка токенов и слежение за сроком их действия
+// Отозванные токены помечаются в Store, отозванные и истекшие убираются из Pool
+// Истекшие в Store не отзываются - это видно по StoredToken.Expired
+type TokenValidator struct {
+	// Service - API с сервисным токеном для secure.checkToken, если nil - проверяем пробным запросом
+	Service       *API
+	Store         TokenStore
+	Pool          *TokenPool
+	ExpiryWarning time.Duration
+	Interval      time.Duration
+	// Refs - какие токены из Store проверять в фоне
+	Refs    func() []TokenRef
+	OnEvent func(e TokenEvent)
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Check - проверяем токен из хранилища и обновляем его данные
+func (v *TokenValidator) Check(ref TokenRef) (st TokenStatus, err error) {
+	t, err := v.Store.Get(ref)
+	if err != nil {
+		return
+	}
+	if t.Revoked {
+		st = TokenStatus{Ref: ref, ExpiresAt: t.ExpiresAt, Scope: t.Scope}
+		return
+	}
+
+	st, err = v.CheckToken(ref, t.AccessToken)
+	if err != nil {
+		v.emit(st, err)
+		return
+	}
+
+	// Проверка не всегда возвращает срок и права - берем сохраненные
+	if st.ExpiresAt.IsZero() {
+		st.ExpiresAt = t.ExpiresAt
+		if t.Expired() {
+			st.Valid = false
+		}
+	}
+	if st.Scope == 0 {
+		st.Scope = t.Scope
+	}
+
+	expired := !st.ExpiresAt.IsZero() && st.CheckedAt.After(st.ExpiresAt)
+
+	switch {
+	case st.Valid:
+		if !st.ExpiresAt.Equal(t.ExpiresAt) || st.Scope != t.Scope {
+			t.ExpiresAt = st.ExpiresAt
+			t.Scope = st.Scope
+			err = v.Store.Put(t)
+		}
+	case expired:
+		v.invalidate(t.AccessToken)
+	default:
+		v.invalidate(t.AccessToken)
+		err = v.Store.Revoke(ref)
+	}
+
+	v.emit(st, nil)
+	return
+}
+
+// CheckToken - проверяем токен без хранилища, err только при временных ошибках
+// Отозванный токен возвращается с Valid = false и помечается в Pool, события не отправляются
+func (v *TokenValidator) CheckToken(ref TokenRef, token string) (st TokenStatus, err error) {
+	st = TokenStatus{Ref: ref, CheckedAt: time.Now()}
+	vk := &API{AccessToken: token}
+
+	switch {
+	case ref.Kind == "group":
+		var ans GroupsGetTokenPermissionsAns
+		ans, err = vk.GroupsGetTokenPermissions()
+		st.Scope = ans.Mask
+
+	case v.Service != nil:
+		var ans SecureCheckTokenAns
+		ans, err = v.Service.SecureCheckToken(map[string]string{"token": token})
+		if err == nil && ans.Success != 1 {
+			v.invalidate(token)
+			return
+		}
+		if ans.Expire > 0 {
+			st.ExpiresAt = time.Unix(ans.Expire, 0)
+		}
+
+	default:
+		st.Scope, err = vk.AccountGetAppPermissions(map[string]string{})
+	}
+
+	if err != nil {
+		if isTokenRevokedErr(err) {
+			err = nil
+			v.invalidate(token)
+		}
+		return
+	}
+
+	st.Valid = true
+	if !st.ExpiresAt.IsZero() && time.Now().After(st.ExpiresAt) {
+		st.Valid = false
+		v.invalidate(token)
+	}
+
+	return
+}
+
+func (v *TokenValidator) invalidate(token string) {
+	if v.Pool != nil {
+		v.Pool.Invalidate(token)
+	}
+}
+
+// Start - запускаем фоновую проверку токенов
+func (v *TokenValidator) Start() {
+	if v.Interval <= 0 {
+		v.Interval = TokenCheckInterval
+	}
+	stop := make(chan struct{})
+	v.stop = stop
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		t := time.NewTicker(v.Interval)
+		defer t.Stop()
+
+		for {
+			v.checkAll()
+
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// Stop - останавливаем фоновую проверку, без Start ничего не делает
+func (v *TokenValidator) Stop() {
+	if v.stop == nil {
+		return
+	}
+	close(v.stop)
+	v.stop = nil
+	v.wg.Wait()
+}
+
+func (v *TokenValidator) checkAll() {
+	if v.Refs == nil {
+		return
+	}
+
+	for _, ref := range v.Refs() {
+		if exited {
+			return
+		}
+
+		_, err := v.Check(ref)
+		if err != nil && err != ErrTokenNotFound {
+			log.Println("[error]", ref, err)
+		}
+	}
+}
+
+// Отправляем событие и считаем метрику
+func (v *TokenValidator) emit(st TokenStatus, err error) {
+	e := TokenEvent{Status: st, Err: err}
+
+	warning := v.ExpiryWarning
+	if warning <= 0 {
+		warning = TokenExpiryWarning
+	}
+
+	switch {
+	case err != nil:
+		e.Type = TokenEventError
+	case !st.ExpiresAt.IsZero() && st.CheckedAt.After(st.ExpiresAt):
+		e.Type = TokenEventExpired
+	case !st.Valid:
+		e.Type = TokenEventRevoked
+	case !st.ExpiresAt.IsZero() && st.ExpiresAt.Sub(st.CheckedAt) < warning:
+		e.Type = TokenEventExpiring
+	default:
+		e.Type = TokenEventValid
+	}
+
+	if promInited {
+		promTokenChecks.WithLabelValues(st.Ref.Kind, e.Type).Inc()
+	}
+
+	if v.OnEvent != nil {
+		v.OnEvent(e)
+	}
+}
+
+// Ошибки ВК, после которых токен уже не заработает
+func isTokenRevokedErr(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "User authorization failed") ||
+		strings.Contains(msg, "invalid token") ||
+		strings.Contains(msg, "invalid access_token")
+}
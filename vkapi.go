@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -42,27 +41,54 @@ func init() {
 
 // GetAuthURL - получаем ссылку для авторизации
 func GetAuthURL(d AuthURLData) string {
-	str := APIAuthURL + fmt.Sprintf("?client_id=%d&redirect_uri=%s&response_type=code", d.ClientID, d.RedirectURI)
+	q := url.Values{}
+	q.Set("client_id", strconv.Itoa(d.ClientID))
+	q.Set("redirect_uri", d.RedirectURI)
+
+	if d.ResponseType != "" {
+		q.Set("response_type", d.ResponseType)
+	} else {
+		q.Set("response_type", "code")
+	}
+
 	if d.V != 0 {
-		str += fmt.Sprintf("&v=%g", d.V)
+		q.Set("v", strconv.FormatFloat(d.V, 'f', -1, 64))
 	} else {
-		str += "&v=" + APIVersion
+		q.Set("v", APIVersion)
 	}
 
-	if d.Scope != "" {
-		str += "&scope=" + d.Scope
+	var scope []string
+	for _, s := range []string{d.Scope, d.UserScopes.String(), d.GroupScopes.String()} {
+		if s != "" {
+			scope = append(scope, s)
+		}
+	}
+	if len(scope) > 0 {
+		q.Set("scope", strings.Join(scope, ","))
 	}
 	if d.GroupIDs != "" {
-		str += "&group_ids=" + d.GroupIDs
+		q.Set("group_ids", d.GroupIDs)
 	}
 	if d.Display != "" {
-		str += "&display=" + d.Display
+		q.Set("display", d.Display)
 	}
 	if d.State != "" {
-		str += "&state=" + d.State
+		q.Set("state", d.State)
+	}
+	if d.CodeChallenge != "" {
+		q.Set("code_challenge", d.CodeChallenge)
+		q.Set("code_challenge_method", "S256")
+	}
+	if d.Revoke {
+		q.Set("revoke", "1")
+	}
+
+	authURL := d.AuthURL
+	if authURL == "" {
+		authURL = APIAuthURL
 	}
 
-	return str
+	return authURL + "?" + q.Encode()
 }
 
 // GetTokenGroup - Получение токена группы
@@ -83,7 +109,33 @@ func (vk *API) GetTokenGroup(d TokenData) (ans map[string]interface{}, err error
 	return
 }
 
+// GetTokenGroups - Получение токенов сообществ, токены разобраны по id сообщества
+// Ошибка сервера авторизации возвращается как *OAuthError, ans.Error тоже заполнен
+func (vk *API) GetTokenGroups(d TokenData) (ans GetTokenGroupAns, err error) {
+	content, err := getToken(d)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	// Парсим ответ
+	ans, err = parseTokenGroupAns(content)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+
+	if ans.Error != "" {
+		err = &OAuthError{Code: ans.Error, Description: ans.ErrorDescription}
+		return
+	}
+
+	return
+}
+
 // GetToken - Получение токена
+// Если в ответе есть error, возвращается *OAuthError, ans.Error и ans.ErrorDescription тоже заполнены
+// Раньше такой ответ возвращался без ошибки и проверять ans.Error надо было самому
 func (vk *API) GetToken(d TokenData) (ans GetTokenAns, err error) {
 	content, err := getToken(d)
 	if err != nil {
@@ -98,23 +150,47 @@ func (vk *API) GetToken(d TokenData) (ans GetTokenAns, err error) {
 		return
 	}
 
+	if ans.Error != "" {
+		err = &OAuthError{Code: ans.Error, Description: ans.ErrorDescription}
+		return
+	}
+
 	return
 }
 
+// GetServiceToken - Получение сервисного токена приложения (client_credentials)
+func (vk *API) GetServiceToken(d TokenData) (ans GetTokenAns, err error) {
+	d.GrantType = "client_credentials"
+	return vk.GetToken(d)
+}
+
 func getToken(d TokenData) (content []byte, err error) {
 	q := url.Values{}
-	q.Add("code", d.Code)
 	q.Add("client_id", strconv.Itoa(d.ClientID))
 	q.Add("client_secret", d.ClientSecret)
-	q.Add("redirect_uri", d.RedirectURI)
 	q.Add("v", APIVersion)
+	if d.GrantType != "" {
+		q.Add("grant_type", d.GrantType)
+	} else {
+		q.Add("code", d.Code)
+		q.Add("redirect_uri", d.RedirectURI)
+	}
+	if d.CodeVerifier != "" {
+		q.Add("code_verifier", d.CodeVerifier)
+	}
+
+	tokenURL := d.TokenURL
+	if tokenURL == "" {
+		tokenURL = APITokenURL
+	}
 
 	// Формируем запрос
-	req, err := http.NewRequest("POST", APITokenURL, strings.NewReader(q.Encode()))
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(q.Encode()))
 	if err != nil {
 		log.Println("[error]", err)
 		return
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// Отправляем запрос
 	client := &http.Client{}
@@ -127,13 +203,6 @@ func getToken(d TokenData) (content []byte, err error) {
 		return
 	}
 
-	// Если статус ответа не правильный
-	if resp.StatusCode != 200 {
-		err = errors.New(resp.Status)
-		log.Println("[error]", resp.Status, resp.StatusCode)
-		return
-	}
-
 	// Читаем ответ
 	content, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -141,6 +210,18 @@ func getToken(d TokenData) (content []byte, err error) {
 		return
 	}
 
+	// Если статус ответа не правильный
+	if resp.StatusCode != 200 {
+		// На неверный code ВК отвечает 401 с описанием ошибки
+		if oerr := parseOAuthError(content); oerr != nil {
+			err = oerr
+		} else {
+			err = errors.New(resp.Status)
+		}
+		log.Println("[error]", resp.Status, resp.StatusCode, string(content))
+		return
+	}
+
 	return
 }
 
@@ -186,6 +267,29 @@ func (vk *API) UsersGetSubscriptions(params map[string]string) (ans UsersGetSubs
 	return
 }
 
+/*
+	Account
+*/
+
+// AccountGetAppPermissions - Получаем битовую маску прав токена пользователя
+func (vk *API) AccountGetAppPermissions(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("account.getAppPermissions", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
 /*
 	Groups
 */
@@ -949,15 +1053,11 @@ func (vk *API) PhotosRestoreComment(params map[string]string) (ans int, err erro
 	return
 }
 
-/*
-	Video
-*/
-
-// VideoGet - Возвращает список видео.
-func (vk *API) VideoGet(params map[string]string) (ans VideoGetAns, err error) {
+// PhotosGetMessagesUploadServer - получаем адрес загрузки фото в сообщения
+func (vk *API) PhotosGetMessagesUploadServer(params map[string]string) (ans UploadServerAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("video.get", params)
+	r, err := vk.request("photos.getMessagesUploadServer", params)
 	if err != nil {
 		return
 	}
@@ -972,11 +1072,11 @@ func (vk *API) VideoGet(params map[string]string) (ans VideoGetAns, err error) {
 	return
 }
 
-// VideoGetComments - Возвращает список комментариев видео.
-func (vk *API) VideoGetComments(params map[string]string) (ans VideoGetCommentsAns, err error) {
+// PhotosGetWallUploadServer - получаем адрес загрузки фото на стену
+func (vk *API) PhotosGetWallUploadServer(params map[string]string) (ans UploadServerAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("video.getComments", params)
+	r, err := vk.request("photos.getWallUploadServer", params)
 	if err != nil {
 		return
 	}
@@ -991,11 +1091,11 @@ func (vk *API) VideoGetComments(params map[string]string) (ans VideoGetCommentsA
 	return
 }
 
-// VideoDeleteComment - Удаление комментарий видео
-func (vk *API) VideoDeleteComment(params map[string]string) (ans int, err error) {
+// PhotosGetUploadServer - получаем адрес загрузки фото в альбом
+func (vk *API) PhotosGetUploadServer(params map[string]string) (ans UploadServerAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("video.deleteComment", params)
+	r, err := vk.request("photos.getUploadServer", params)
 	if err != nil {
 		return
 	}
@@ -1010,11 +1110,11 @@ func (vk *API) VideoDeleteComment(params map[string]string) (ans int, err error)
 	return
 }
 
-// VideoRestoreComment - Восстановление комментарий видео
-func (vk *API) VideoRestoreComment(params map[string]string) (ans int, err error) {
+// PhotosSaveMessagesPhoto - сохраняем загруженное фото для сообщения
+func (vk *API) PhotosSaveMessagesPhoto(params map[string]string) (ans []PhotosGetItem, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("video.restoreComment", params)
+	r, err := vk.request("photos.saveMessagesPhoto", params)
 	if err != nil {
 		return
 	}
@@ -1029,15 +1129,11 @@ func (vk *API) VideoRestoreComment(params map[string]string) (ans int, err error
 	return
 }
 
-/*
-	Message
-*/
-
-// MessagesSend - отправка сообщений
-func (vk *API) MessagesSend(params map[string]string) (ans int, err error) {
+// PhotosSaveWallPhoto - сохраняем загруженное фото для стены
+func (vk *API) PhotosSaveWallPhoto(params map[string]string) (ans []PhotosGetItem, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("messages.send", params)
+	r, err := vk.request("photos.saveWallPhoto", params)
 	if err != nil {
 		return
 	}
@@ -1052,11 +1148,11 @@ func (vk *API) MessagesSend(params map[string]string) (ans int, err error) {
 	return
 }
 
-// MessagesIsMessagesFromGroupAllowed - проверяем разрешена ли отправка сообщений от имени сообщества
-func (vk *API) MessagesIsMessagesFromGroupAllowed(params map[string]string) (ans MessagesIsMessagesFromGroupAllowedAns, err error) {
+// PhotosSave - сохраняем загруженные фото в альбом
+func (vk *API) PhotosSave(params map[string]string) (ans []PhotosGetItem, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("messages.isMessagesFromGroupAllowed", params)
+	r, err := vk.request("photos.save", params)
 	if err != nil {
 		return
 	}
@@ -1072,14 +1168,14 @@ func (vk *API) MessagesIsMessagesFromGroupAllowed(params map[string]string) (ans
 }
 
 /*
-	Utils
+	Video
 */
 
-// UtilsGetShortLink - Получаем сокращенную ссылку
-func (vk *API) UtilsGetShortLink(params map[string]string) (ans UtilsGetShortLinkAns, err error) {
+// VideoGet - Возвращает список видео.
+func (vk *API) VideoGet(params map[string]string) (ans VideoGetAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("utils.getShortLink", params)
+	r, err := vk.request("video.get", params)
 	if err != nil {
 		return
 	}
@@ -1094,20 +1190,15 @@ func (vk *API) UtilsGetShortLink(params map[string]string) (ans UtilsGetShortLin
 	return
 }
 
-// UtilsGetLinkStats - Получаем статистику по ссылке
-func (vk *API) UtilsGetLinkStats(params map[string]string) (ans UtilsGetLinkStatsAns, err error) {
+// VideoGetComments - Возвращает список комментариев видео.
+func (vk *API) VideoGetComments(params map[string]string) (ans VideoGetCommentsAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("utils.getLinkStats", params)
+	r, err := vk.request("video.getComments", params)
 	if err != nil {
 		return
 	}
 
-	// Если ответ пустой
-	if string(r.Response) == "[]" {
-		return
-	}
-
 	// Парсим данные
 	err = json.Unmarshal(r.Response, &ans)
 	if err != nil {
@@ -1118,11 +1209,11 @@ func (vk *API) UtilsGetLinkStats(params map[string]string) (ans UtilsGetLinkStat
 	return
 }
 
-// UtilsResolveScreenName - Получаем сокращенную ссылку
-func (vk *API) UtilsResolveScreenName(params map[string]string) (ans UtilsResolveScreenNameAns, err error) {
+// VideoDeleteComment - Удаление комментарий видео
+func (vk *API) VideoDeleteComment(params map[string]string) (ans int, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("utils.resolveScreenName", params)
+	r, err := vk.request("video.deleteComment", params)
 	if err != nil {
 		return
 	}
@@ -1137,15 +1228,11 @@ func (vk *API) UtilsResolveScreenName(params map[string]string) (ans UtilsResolv
 	return
 }
 
-/*
-	Market
-*/
-
-// MarketGet - получаем список товаров
-func (vk *API) MarketGet(params map[string]string) (ans MarketGetAns, err error) {
+// VideoRestoreComment - Восстановление комментарий видео
+func (vk *API) VideoRestoreComment(params map[string]string) (ans int, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("market.get", params)
+	r, err := vk.request("video.restoreComment", params)
 	if err != nil {
 		return
 	}
@@ -1160,11 +1247,11 @@ func (vk *API) MarketGet(params map[string]string) (ans MarketGetAns, err error)
 	return
 }
 
-// MarketDeleteComment - удаляем комментарий у товаров
-func (vk *API) MarketDeleteComment(params map[string]string) (ans int, err error) {
+// VideoSave - получаем адрес загрузки видео
+func (vk *API) VideoSave(params map[string]string) (ans VideoSaveAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("market.deleteComment", params)
+	r, err := vk.request("video.save", params)
 	if err != nil {
 		return
 	}
@@ -1179,11 +1266,15 @@ func (vk *API) MarketDeleteComment(params map[string]string) (ans int, err error
 	return
 }
 
-// MarketRestoreComment - восстанавливаем комментарий у товаров
-func (vk *API) MarketRestoreComment(params map[string]string) (ans int, err error) {
+/*
+	Docs
+*/
+
+// DocsGetMessagesUploadServer - получаем адрес загрузки документа в сообщения
+func (vk *API) DocsGetMessagesUploadServer(params map[string]string) (ans UploadServerAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("market.restoreComment", params)
+	r, err := vk.request("docs.getMessagesUploadServer", params)
 	if err != nil {
 		return
 	}
@@ -1198,15 +1289,11 @@ func (vk *API) MarketRestoreComment(params map[string]string) (ans int, err erro
 	return
 }
 
-/*
-	Ads
-*/
-
-// AdsGetAccounts - Получаем список аккаунтов
-func (vk *API) AdsGetAccounts(params map[string]string) (ans []AdsGetAccountsAns, err error) {
+// DocsGetWallUploadServer - получаем адрес загрузки документа на стену
+func (vk *API) DocsGetWallUploadServer(params map[string]string) (ans UploadServerAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getAccounts", params)
+	r, err := vk.request("docs.getWallUploadServer", params)
 	if err != nil {
 		return
 	}
@@ -1221,11 +1308,11 @@ func (vk *API) AdsGetAccounts(params map[string]string) (ans []AdsGetAccountsAns
 	return
 }
 
-// AdsСreateTargetGroup - Создаем группу ретаргетинга
-func (vk *API) AdsСreateTargetGroup(params map[string]string) (ans AdsСreateTargetGroupAns, err error) {
+// DocsSave - сохраняем загруженный документ
+func (vk *API) DocsSave(params map[string]string) (ans DocsSaveAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.createTargetGroup", params)
+	r, err := vk.request("docs.save", params)
 	if err != nil {
 		return
 	}
@@ -1240,11 +1327,20 @@ func (vk *API) AdsСreateTargetGroup(params map[string]string) (ans AdsСreateTa
 	return
 }
 
-// AdsDeleteTargetGroup - удаляем группу ретаргетинга
-func (vk *API) AdsDeleteTargetGroup(params map[string]string) (ans int, err error) {
+/*
+	Message
+*/
+
+// MessagesSend - отправка сообщений
+// Если random_id не задан, генерируем его. Он не меняется при повторах запроса, поэтому сообщение не задвоится
+func (vk *API) MessagesSend(params map[string]string) (ans int, err error) {
+	if params["random_id"] == "" {
+		params = copyParams(params)
+		params["random_id"] = strconv.Itoa(int(NewRandomID()))
+	}
 
 	// Отправляем запрос
-	r, err := vk.request("ads.deleteTargetGroup", params)
+	r, err := vk.request("messages.send", params)
 	if err != nil {
 		return
 	}
@@ -1259,11 +1355,15 @@ func (vk *API) AdsDeleteTargetGroup(params map[string]string) (ans int, err erro
 	return
 }
 
-// AdsImportTargetContacts - добавиление контактов в группу ретаргета
-func (vk *API) AdsImportTargetContacts(params map[string]string) (ans int, err error) {
+// MessagesSendPeerIDs - отправка сообщения нескольким получателям (peer_ids), результат по каждому
+func (vk *API) MessagesSendPeerIDs(params map[string]string) (ans []MessagesSendPeerAns, err error) {
+	if params["random_id"] == "" {
+		params = copyParams(params)
+		params["random_id"] = strconv.Itoa(int(NewRandomID()))
+	}
 
 	// Отправляем запрос
-	r, err := vk.request("ads.importTargetContacts", params)
+	r, err := vk.request("messages.send", params)
 	if err != nil {
 		return
 	}
@@ -1278,11 +1378,20 @@ func (vk *API) AdsImportTargetContacts(params map[string]string) (ans int, err e
 	return
 }
 
-// AdsGetSuggestions - получение подсказок к рекламе
-func (vk *API) AdsGetSuggestions(params map[string]string) (ans []AdsGetSuggestionsAns, err error) {
+// MessagesSendIdempotent - отправка сообщения с ключом идемпотентности
+// random_id вычисляется из ключа, поэтому повторная отправка с тем же ключом не создаст дубль
+func (vk *API) MessagesSendIdempotent(key string, params map[string]string) (ans int, err error) {
+	params = copyParams(params)
+	params["random_id"] = strconv.Itoa(int(RandomIDFromKey(key)))
+
+	return vk.MessagesSend(params)
+}
+
+// MessagesIsMessagesFromGroupAllowed - проверяем разрешена ли отправка сообщений от имени сообщества
+func (vk *API) MessagesIsMessagesFromGroupAllowed(params map[string]string) (ans MessagesIsMessagesFromGroupAllowedAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getSuggestions", params)
+	r, err := vk.request("messages.isMessagesFromGroupAllowed", params)
 	if err != nil {
 		return
 	}
@@ -1290,29 +1399,6 @@ func (vk *API) AdsGetSuggestions(params map[string]string) (ans []AdsGetSuggesti
 	// Парсим данные
 	err = json.Unmarshal(r.Response, &ans)
 	if err != nil {
-		// VK BUG FIX
-		if strings.Contains(err.Error(), "cannot unmarshal string") {
-			var arr []AdsGetSuggestionsAnsStr
-			err = json.Unmarshal(r.Response, &arr)
-			if err != nil {
-				log.Println("[error]", err, string(r.Response))
-				return
-			}
-
-			ans = []AdsGetSuggestionsAns{}
-			for _, a := range arr {
-				id, _ := strconv.ParseInt(a.ID, 10, 64)
-
-				ans = append(ans, AdsGetSuggestionsAns{
-					ID:     int(id),
-					Name:   a.Name,
-					Type:   a.Type,
-					Parent: a.Parent,
-				})
-			}
-			return
-		}
-
 		log.Println("[error]", err, string(r.Response))
 		return
 	}
@@ -1320,11 +1406,11 @@ func (vk *API) AdsGetSuggestions(params map[string]string) (ans []AdsGetSuggesti
 	return
 }
 
-// AdsGetTargetGroups - получение групп ретаргета
-func (vk *API) AdsGetTargetGroups(params map[string]string) (ans []AdsGetTargetGroupsAns, err error) {
+// MessagesGetConversations - получаем список бесед
+func (vk *API) MessagesGetConversations(params map[string]string) (ans MessagesGetConversationsAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getTargetGroups", params)
+	r, err := vk.request("messages.getConversations", params)
 	if err != nil {
 		return
 	}
@@ -1339,11 +1425,11 @@ func (vk *API) AdsGetTargetGroups(params map[string]string) (ans []AdsGetTargetG
 	return
 }
 
-// AdsGetTargetingStats - Смотрим размер аудитории
-func (vk *API) AdsGetTargetingStats(params map[string]string) (ans AdsGetTargetingStatsAns, err error) {
+// MessagesGetHistory - получаем историю сообщений диалога
+func (vk *API) MessagesGetHistory(params map[string]string) (ans MessagesGetHistoryAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getTargetingStats", params)
+	r, err := vk.request("messages.getHistory", params)
 	if err != nil {
 		return
 	}
@@ -1358,11 +1444,11 @@ func (vk *API) AdsGetTargetingStats(params map[string]string) (ans AdsGetTargeti
 	return
 }
 
-// AdsGetCampaigns - Получаем список кампаний
-func (vk *API) AdsGetCampaigns(params map[string]string) (ans []AdsGetCampaignsAns, err error) {
+// MessagesGetByID - получаем сообщения по их ID
+func (vk *API) MessagesGetByID(params map[string]string) (ans MessagesGetByIDAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getCampaigns", params)
+	r, err := vk.request("messages.getById", params)
 	if err != nil {
 		return
 	}
@@ -1377,11 +1463,11 @@ func (vk *API) AdsGetCampaigns(params map[string]string) (ans []AdsGetCampaignsA
 	return
 }
 
-// AdsGetAds - Получаем список объявлений
-func (vk *API) AdsGetAds(params map[string]string) (ans []AdsGetAdsAns, err error) {
+// MessagesGetByConversationMessageID - получаем сообщения по их ID внутри беседы
+func (vk *API) MessagesGetByConversationMessageID(params map[string]string) (ans MessagesGetByIDAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getAds", params)
+	r, err := vk.request("messages.getByConversationMessageId", params)
 	if err != nil {
 		return
 	}
@@ -1396,11 +1482,11 @@ func (vk *API) AdsGetAds(params map[string]string) (ans []AdsGetAdsAns, err erro
 	return
 }
 
-// AdsGetAdsLayout - Получаем список список объявлений
-func (vk *API) AdsGetAdsLayout(params map[string]string) (ans []AdsGetAdsLayoutAns, err error) {
+// MessagesGetConversationMembers - получаем участников беседы
+func (vk *API) MessagesGetConversationMembers(params map[string]string) (ans MessagesGetConversationMembersAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getAdsLayout", params)
+	r, err := vk.request("messages.getConversationMembers", params)
 	if err != nil {
 		return
 	}
@@ -1415,11 +1501,11 @@ func (vk *API) AdsGetAdsLayout(params map[string]string) (ans []AdsGetAdsLayoutA
 	return
 }
 
-// AdsGetStatistics - Получаем статистику объявлений
-func (vk *API) AdsGetStatistics(params map[string]string) (ans []AdsGetStatisticsAns, err error) {
+// MessagesSearchConversations - ищем беседы
+func (vk *API) MessagesSearchConversations(params map[string]string) (ans MessagesSearchConversationsAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getStatistics", params)
+	r, err := vk.request("messages.searchConversations", params)
 	if err != nil {
 		return
 	}
@@ -1431,46 +1517,14 @@ func (vk *API) AdsGetStatistics(params map[string]string) (ans []AdsGetStatistic
 		return
 	}
 
-	for i, d := range ans {
-		// Создаем массив для норм статы
-		ans[i].Stats = make([]AdsGetStatisticsAnsStats, len(d.StatsBug))
-
-		for k, s := range d.StatsBug {
-			// Пробуем норм разобрать
-			var t AdsGetStatisticsAnsStats
-			err = json.Unmarshal(s, &t)
-			// Если ошибка пробуем разобрать кривой json
-			if err != nil {
-				var t2 AdsGetStatisticsAnsStatsBug
-				err = json.Unmarshal(s, &t2)
-				if err != nil {
-					log.Println("[error]", err, string(r.Response))
-					return
-				}
-
-				impr, _ := strconv.ParseInt(t2.Impressions, 10, 32)
-
-				t = AdsGetStatisticsAnsStats{
-					Day:         t2.Day,
-					Spent:       t2.Spent,
-					Clicks:      t2.Clicks,
-					Reach:       t2.Reach,
-					Impressions: int(impr),
-				}
-			}
-
-			ans[i].Stats[k] = t
-		}
-	}
-
 	return
 }
 
-// AdsGetDemographics - Получаем статистику объявлений демографическую
-func (vk *API) AdsGetDemographics(params map[string]string) (ans []AdsGetDemographicsAns, err error) {
+// MessagesGetInviteLink - получаем ссылку приглашения в беседу
+func (vk *API) MessagesGetInviteLink(params map[string]string) (ans MessagesGetInviteLinkAns, err error) {
 
 	// Отправляем запрос
-	r, err := vk.request("ads.getDemographics", params)
+	r, err := vk.request("messages.getInviteLink", params)
 	if err != nil {
 		return
 	}
@@ -1485,12 +1539,559 @@ func (vk *API) AdsGetDemographics(params map[string]string) (ans []AdsGetDemogra
 	return
 }
 
-/*
-	Stats
-*/
+// MessagesEdit - редактирование сообщения
+func (vk *API) MessagesEdit(params map[string]string) (ans int, err error) {
 
-// StatsGet - Получаем стату страницы
-func (vk *API) StatsGet(params map[string]string) (ans []StatsGetAns, err error) {
+	// Отправляем запрос
+	r, err := vk.request("messages.edit", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MessagesDelete - удаление сообщений
+func (vk *API) MessagesDelete(params map[string]string) (ans map[string]int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("messages.delete", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MessagesPin - закрепляем сообщение
+func (vk *API) MessagesPin(params map[string]string) (ans MessagesGetAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("messages.pin", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MessagesUnpin - открепляем сообщение
+func (vk *API) MessagesUnpin(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("messages.unpin", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MessagesMarkAsRead - помечаем сообщения прочитанными
+func (vk *API) MessagesMarkAsRead(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("messages.markAsRead", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MessagesSetActivity - показываем что пишем сообщение
+func (vk *API) MessagesSetActivity(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("messages.setActivity", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MessagesSendMessageEventAnswer - ответ на нажатие callback кнопки
+func (vk *API) MessagesSendMessageEventAnswer(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("messages.sendMessageEventAnswer", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+/*
+	Utils
+*/
+
+// UtilsGetShortLink - Получаем сокращенную ссылку
+func (vk *API) UtilsGetShortLink(params map[string]string) (ans UtilsGetShortLinkAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("utils.getShortLink", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// UtilsGetLinkStats - Получаем статистику по ссылке
+func (vk *API) UtilsGetLinkStats(params map[string]string) (ans UtilsGetLinkStatsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("utils.getLinkStats", params)
+	if err != nil {
+		return
+	}
+
+	// Если ответ пустой
+	if string(r.Response) == "[]" {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// UtilsResolveScreenName - Получаем сокращенную ссылку
+func (vk *API) UtilsResolveScreenName(params map[string]string) (ans UtilsResolveScreenNameAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("utils.resolveScreenName", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+/*
+	Market
+*/
+
+// MarketGet - получаем список товаров
+func (vk *API) MarketGet(params map[string]string) (ans MarketGetAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("market.get", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MarketDeleteComment - удаляем комментарий у товаров
+func (vk *API) MarketDeleteComment(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("market.deleteComment", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// MarketRestoreComment - восстанавливаем комментарий у товаров
+func (vk *API) MarketRestoreComment(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("market.restoreComment", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+/*
+	Ads
+*/
+
+// AdsGetAccounts - Получаем список аккаунтов
+func (vk *API) AdsGetAccounts(params map[string]string) (ans []AdsGetAccountsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getAccounts", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsСreateTargetGroup - Создаем группу ретаргетинга
+func (vk *API) AdsСreateTargetGroup(params map[string]string) (ans AdsСreateTargetGroupAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.createTargetGroup", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsDeleteTargetGroup - удаляем группу ретаргетинга
+func (vk *API) AdsDeleteTargetGroup(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.deleteTargetGroup", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsImportTargetContacts - добавиление контактов в группу ретаргета
+func (vk *API) AdsImportTargetContacts(params map[string]string) (ans int, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.importTargetContacts", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsGetSuggestions - получение подсказок к рекламе
+func (vk *API) AdsGetSuggestions(params map[string]string) (ans []AdsGetSuggestionsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getSuggestions", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		// VK BUG FIX
+		if strings.Contains(err.Error(), "cannot unmarshal string") {
+			var arr []AdsGetSuggestionsAnsStr
+			err = json.Unmarshal(r.Response, &arr)
+			if err != nil {
+				log.Println("[error]", err, string(r.Response))
+				return
+			}
+
+			ans = []AdsGetSuggestionsAns{}
+			for _, a := range arr {
+				id, _ := strconv.ParseInt(a.ID, 10, 64)
+
+				ans = append(ans, AdsGetSuggestionsAns{
+					ID:     int(id),
+					Name:   a.Name,
+					Type:   a.Type,
+					Parent: a.Parent,
+				})
+			}
+			return
+		}
+
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsGetTargetGroups - получение групп ретаргета
+func (vk *API) AdsGetTargetGroups(params map[string]string) (ans []AdsGetTargetGroupsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getTargetGroups", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsGetTargetingStats - Смотрим размер аудитории
+func (vk *API) AdsGetTargetingStats(params map[string]string) (ans AdsGetTargetingStatsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getTargetingStats", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsGetCampaigns - Получаем список кампаний
+func (vk *API) AdsGetCampaigns(params map[string]string) (ans []AdsGetCampaignsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getCampaigns", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsGetAds - Получаем список объявлений
+func (vk *API) AdsGetAds(params map[string]string) (ans []AdsGetAdsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getAds", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsGetAdsLayout - Получаем список список объявлений
+func (vk *API) AdsGetAdsLayout(params map[string]string) (ans []AdsGetAdsLayoutAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getAdsLayout", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+// AdsGetStatistics - Получаем статистику объявлений
+func (vk *API) AdsGetStatistics(params map[string]string) (ans []AdsGetStatisticsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getStatistics", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	for i, d := range ans {
+		// Создаем массив для норм статы
+		ans[i].Stats = make([]AdsGetStatisticsAnsStats, len(d.StatsBug))
+
+		for k, s := range d.StatsBug {
+			// Пробуем норм разобрать
+			var t AdsGetStatisticsAnsStats
+			err = json.Unmarshal(s, &t)
+			// Если ошибка пробуем разобрать кривой json
+			if err != nil {
+				var t2 AdsGetStatisticsAnsStatsBug
+				err = json.Unmarshal(s, &t2)
+				if err != nil {
+					log.Println("[error]", err, string(r.Response))
+					return
+				}
+
+				impr, _ := strconv.ParseInt(t2.Impressions, 10, 32)
+
+				t = AdsGetStatisticsAnsStats{
+					Day:         t2.Day,
+					Spent:       t2.Spent,
+					Clicks:      t2.Clicks,
+					Reach:       t2.Reach,
+					Impressions: int(impr),
+				}
+			}
+
+			ans[i].Stats[k] = t
+		}
+	}
+
+	return
+}
+
+// AdsGetDemographics - Получаем статистику объявлений демографическую
+func (vk *API) AdsGetDemographics(params map[string]string) (ans []AdsGetDemographicsAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("ads.getDemographics", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
+/*
+	Stats
+*/
+
+// StatsGet - Получаем стату страницы
+func (vk *API) StatsGet(params map[string]string) (ans []StatsGetAns, err error) {
 
 	// Отправляем запрос
 	r, err := vk.request("stats.get", params)
@@ -1527,6 +2128,29 @@ func (vk *API) StatsGetPostReach(params map[string]string) (ans []StatsGetPostRe
 	return
 }
 
+/*
+	Secure
+*/
+
+// SecureCheckToken - Проверяем токен пользователя, нужен сервисный токен
+func (vk *API) SecureCheckToken(params map[string]string) (ans SecureCheckTokenAns, err error) {
+
+	// Отправляем запрос
+	r, err := vk.request("secure.checkToken", params)
+	if err != nil {
+		return
+	}
+
+	// Парсим данные
+	err = json.Unmarshal(r.Response, &ans)
+	if err != nil {
+		log.Println("[error]", err, string(r.Response))
+		return
+	}
+
+	return
+}
+
 /*
 	Execute
 */
@@ -1558,6 +2182,17 @@ func (vk *API) Execute(code string) (r Response, err error) {
 	Запрос к ВК
 */
 
+// APIError - ошибка, которую вернул ВК, текст ошибки как в error_msg
+type APIError struct {
+	Method string
+	Code   int
+	msg    string
+}
+
+func (e *APIError) Error() string {
+	return e.msg
+}
+
 // Обертка для запроса к ВК
 func (vk *API) request(method string, params map[string]string) (ans Response, err error) {
 	// прометей
@@ -1569,14 +2204,17 @@ func (vk *API) request(method string, params map[string]string) (ans Response, e
 		}(tn)
 	}
 
-	if vk.AccessToken == "" {
-		err = errors.New("no access token")
-		log.Println("[error]", err)
-		return
-	}
-
+	var captchaAttempts, validationAttempts int
 	for {
-		ans, err = vk.fullRequest(method, params)
+		var token string
+		var fromPool bool
+		token, fromPool, err = vk.token(method)
+		if err != nil {
+			log.Println("[error]", err)
+			return
+		}
+
+		ans, err = vk.fullRequest(method, params, token)
 		if err != nil {
 			if httpErrorReg.MatchString(err.Error()) {
 				if vk.httpErrorWait(method) {
@@ -1593,11 +2231,41 @@ func (vk *API) request(method string, params map[string]string) (ans Response, e
 				if vk.floodWait(method) {
 					continue
 				}
+			} else if fromPool && ans.Error.ErrorCode == 5 {
+				// Токен из пула отозван - берем следующий
+				vk.Pool.Invalidate(token)
+				continue
+			} else if ans.Error.ErrorCode == 14 {
+				// Нужна капча - повторяем запрос с ответом
+				var key string
+				captchaAttempts++
+				key, err = vk.solveCaptcha(method, ans.Error, captchaAttempts)
+				if err != nil {
+					return
+				}
+
+				params = copyParams(params)
+				params["captcha_sid"] = ans.Error.CaptchaSid
+				params["captcha_key"] = key
+				continue
+			} else if ans.Error.ErrorCode == 17 || ans.Error.ErrorCode == 24 {
+				// Нужна проверка пользователем или подтверждение действия
+				validationAttempts++
+				err = vk.validate(method, ans.Error, validationAttempts)
+				if err != nil {
+					return
+				}
+
+				if ans.Error.ErrorCode == 24 {
+					params = copyParams(params)
+					params["confirm"] = "1"
+				}
+				continue
 			} else if ans.Error.ErrorMsg == "Runtime error occurred during code invocation: Comparing values of different or unsupported types" {
 				log.Println("[error]", params["code"])
 			}
 
-			err = errors.New(ans.Error.ErrorMsg)
+			err = &APIError{Method: method, Code: ans.Error.ErrorCode, msg: ans.Error.ErrorMsg}
 			return
 		}
 
@@ -1608,7 +2276,7 @@ func (vk *API) request(method string, params map[string]string) (ans Response, e
 }
 
 // Запрос к ВК
-func (vk *API) fullRequest(method string, params map[string]string) (ans Response, err error) {
+func (vk *API) fullRequest(method string, params map[string]string, token string) (ans Response, err error) {
 	if statRqCollect {
 		// Проверим что очередь не переполнена
 		if len(statRqChan) <= statRqQueueLen {
@@ -1631,7 +2299,7 @@ func (vk *API) fullRequest(method string, params map[string]string) (ans Respons
 	if params["v"] == "" {
 		q.Add("v", APIVersion)
 	}
-	q.Add("access_token", vk.AccessToken)
+	q.Add("access_token", token)
 
 	// Формируем запрос
 	req, err := http.NewRequest("POST", APIMethodURL+method, strings.NewReader(q.Encode()))
@@ -1642,7 +2310,7 @@ func (vk *API) fullRequest(method string, params map[string]string) (ans Respons
 
 	// Добавляем контекст
 	ctx, cancel := context.WithCancel(context.Background())
-	key := vk.AccessToken + "_" + strconv.FormatInt(time.Now().UnixNano(), 32)
+	key := token + "_" + strconv.FormatInt(time.Now().UnixNano(), 32)
 	contMap.Lock()
 	contMap.h[key] = cancel
 	contMap.Unlock()